@@ -0,0 +1,144 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "slices"
+  "strings"
+)
+
+/* The result of an attack: a to-hit roll, and the damage roll if the
+ * attack wasn't a fumble.
+ * Natural is the face shown on the d20 that was kept for the to-hit roll.
+ */
+type AttackResult struct {
+  ToHitExpression string
+  ToHit RollRow
+  Natural int
+  Critical bool
+  Fumble bool
+  // Nil when the attack was a fumble, since no damage is rolled
+  Damage *RollRow
+}
+
+/* Error returned when the to-hit or damage expression of an attack
+ * fails to parse or roll. Expression is the one that failed.
+ */
+type AttackError struct {
+  Expression string
+  Err error
+}
+
+func (e *AttackError) Error() string {
+  return e.Err.Error()
+}
+
+func (e *AttackError) Unwrap() error {
+  return e.Err
+}
+
+/* Rolls an attack. The to-hit roll may be a full expression with a d20,
+ * ex. 2d20kh1+7, or just a bonus such as +7, 7 or +5+d4, which is added to a d20.
+ * A natural roll of critOn or higher is a critical hit, which doubles
+ * the damage dice but not the numbers added to them, and a natural 1
+ * is a fumble, which rolls no damage.
+ */
+func RollAttack(toHit string, damage string, critOn int, options EvalOptions) (*AttackResult, error) {
+  if critOn < 2 || critOn > 20 {
+    return nil, errors.New("Critical hits must happen on a natural roll from 2 to 20")
+  }
+
+  // A to-hit without a d20 is a bonus, ex. +7 or +5+d4, unless it's
+  // another roll entirely, ex. d12+3, which is reported below
+  bonus := strings.TrimSpace(toHit)
+  signed := strings.HasPrefix(bonus, "+") || strings.HasPrefix(bonus, "-")
+  if tree, err := parse(toHit); err == nil && !hasDice(tree, isD20) && (signed || !hasDice(tree, nil)) {
+    if !signed {
+      bonus = "+" + bonus
+    }
+    toHit = "d20" + bonus
+  }
+
+  // Parse both before rolling anything, so a mistake in the damage
+  // doesn't waste the to-hit roll
+  toHitTree, err := parse(toHit)
+  if err != nil {
+    return nil, &AttackError{Expression: toHit, Err: err}
+  }
+  damageTree, err := parse(damage)
+  if err != nil {
+    return nil, &AttackError{Expression: damage, Err: err}
+  }
+
+  row, err := rollTree(toHitTree, options)
+  if err != nil {
+    return nil, &AttackError{Expression: toHit, Err: err}
+  }
+  attack := &AttackResult{ToHitExpression: toHit, ToHit: row, Natural: naturalRoll(row.Rolls)}
+  if attack.Natural == 0 {
+    return nil, errors.New(fmt.Sprintf("The to-hit roll `%s` needs a d20 in it", toHit))
+  }
+  attack.Critical = attack.Natural >= critOn
+  attack.Fumble = attack.Natural == 1
+  if attack.Fumble {
+    return attack, nil
+  }
+
+  damageOptions := options
+  damageOptions.Critical = attack.Critical
+  row, err = rollTree(damageTree, damageOptions)
+  if err != nil {
+    return nil, &AttackError{Expression: damage, Err: err}
+  }
+  attack.Damage = &row
+  return attack, nil
+}
+
+/* Checks whether an expression rolls any dice that match,
+ * or any dice at all if match is nil.
+ */
+func hasDice(node Node, match func(diceSpec) bool) bool {
+  switch n := node.(type) {
+  case DiceNode:
+    return match == nil || match(n.spec)
+  case LabelNode:
+    return hasDice(n.Operand, match)
+  case UnaryNode:
+    return hasDice(n.Operand, match)
+  case BinaryNode:
+    return hasDice(n.Left, match) || hasDice(n.Right, match)
+  case ComparisonNode:
+    return hasDice(n.Left, match) || hasDice(n.Right, match)
+  case ConditionalNode:
+    return hasDice(n.Condition, match) || hasDice(n.Then, match) || hasDice(n.Else, match)
+  case FunctionNode:
+    return slices.ContainsFunc(n.Args, func(arg Node) bool {
+      return hasDice(arg, match)
+    })
+  }
+  return false
+}
+
+/* Checks whether dice are ordinary d20s. */
+func isD20(spec diceSpec) bool {
+  return spec.sides == 20 && spec.custom == "" && !spec.fate
+}
+
+/* Finds the face of the first d20 that counted towards a roll,
+ * skipping any that were dropped or rerolled. Custom and Fate dice
+ * never count, even with 20 faces. Returns 0 if no d20 was rolled.
+ */
+func naturalRoll(rolls []DiceRoll) int {
+  for _, r := range rolls {
+    if r.Sides != 20 || r.Faces != nil || r.Fate {
+      continue
+    }
+    for i, result := range r.Results {
+      if i < len(r.Flags) && r.Flags[i] & (DieDropped | DieRerolled) != 0 {
+        continue
+      }
+      return result
+    }
+  }
+  return 0
+}
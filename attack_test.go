@@ -0,0 +1,92 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "testing"
+)
+
+/* Test hits, critical hits and fumbles against known rolls */
+func TestRollAttack(t *testing.T) {
+  cases := []struct {
+    toHit string
+    damage string
+    critOn int
+    faces []int
+    toHitResult int
+    natural int
+    critical bool
+    fumble bool
+    damageResult int
+    damageDice int
+  }{
+    {"+7", "2d6+4", 20, []int{12, 3, 5}, 19, 12, false, false, 12, 2},
+    {"7", "2d6+4", 20, []int{20, 3, 5, 6, 1}, 27, 20, true, false, 19, 4},
+    {"d20+7", "2d6+4", 19, []int{19, 3, 5, 6, 1}, 26, 19, true, false, 19, 4},
+    {"d20+7", "2d6+4", 19, []int{18, 3, 5}, 25, 18, false, false, 12, 2},
+    {"+7", "2d6+4", 20, []int{1}, 8, 1, false, true, 0, 0},
+    {"2d20kh1-1", "d8", 20, []int{4, 20, 2, 7}, 19, 20, true, false, 9, 2},
+    {"2d20kl1", "d8", 20, []int{20, 3, 5}, 3, 3, false, false, 5, 1},
+    {"+5+d4", "d8", 20, []int{10, 3, 6}, 18, 10, false, false, 6, 1},
+    {"+7", "4d6kh3+2", 20, []int{20, 6, 5, 4, 3, 2, 1, 1, 1}, 27, 20, true, false, 23, 8},
+  }
+
+  for _, c := range cases {
+    attack, err := RollAttack(c.toHit, c.damage, c.critOn, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Attack %s / %s failed with error: %s", c.toHit, c.damage, err)
+    }
+    if attack.ToHit.Result != c.toHitResult || attack.Natural != c.natural {
+      t.Fatalf("Attack %s with %v: to hit was %d (natural %d) instead of %d (natural %d)", c.toHit, c.faces, attack.ToHit.Result, attack.Natural, c.toHitResult, c.natural)
+    }
+    if attack.Critical != c.critical || attack.Fumble != c.fumble {
+      t.Fatalf("Attack %s with %v: critical %t and fumble %t, expected %t and %t", c.toHit, c.faces, attack.Critical, attack.Fumble, c.critical, c.fumble)
+    }
+    if c.fumble {
+      if attack.Damage != nil {
+        t.Fatalf("Attack %s with %v: a fumble should not roll damage", c.toHit, c.faces)
+      }
+      continue
+    }
+    if attack.Damage.Result != c.damageResult || len(attack.Damage.Rolls[0].Results) != c.damageDice {
+      t.Fatalf("Attack %s with %v: damage was %d from %v instead of %d from %d dice", c.toHit, c.faces, attack.Damage.Result, attack.Damage.Rolls[0].Results, c.damageResult, c.damageDice)
+    }
+  }
+}
+
+/* Test that mistakes in an attack are reported against the right expression */
+func TestRollAttackErrors(t *testing.T) {
+  var attackError *AttackError
+  if _, err := RollAttack("+7", "2d6 +", 20, EvalOptions{}); !errors.As(err, &attackError) || attackError.Expression != "2d6 +" {
+    t.Fatalf("A bad damage roll should be reported against the damage, but got: %v", err)
+  }
+  if _, err := RollAttack("d20 + x", "2d6", 20, EvalOptions{}); !errors.As(err, &attackError) || attackError.Expression != "d20 + x" {
+    t.Fatalf("A bad to-hit roll should be reported against the to-hit, but got: %v", err)
+  }
+  if _, err := RollAttack("d12+3", "2d6", 20, EvalOptions{}); err == nil {
+    t.Fatalf("A to-hit roll without a d20 should fail")
+  }
+  if _, err := RollAttack("+3", "2d6", 1, EvalOptions{}); err == nil {
+    t.Fatalf("Critting on a natural 1 should not be allowed")
+  }
+}
+
+/* Test that a custom die with 20 faces isn't mistaken for the d20 */
+func TestRollAttackCustomDice(t *testing.T) {
+  faces := "1"
+  for i := 2; i <= 20; i++ {
+    faces += fmt.Sprintf(", %d", i)
+  }
+  options := EvalOptions{
+    Roller: &scriptedRoller{faces: []int{20, 5, 3}},
+    CustomDice: map[string]CustomDie{"twenty": mustDefineDie("twenty", faces)},
+  }
+
+  attack, err := RollAttack("d{twenty} + d20", "d6", 20, options)
+  if err != nil {
+    t.Fatalf("Attack with a custom die failed with error: %s", err)
+  }
+  if attack.Natural != 5 || attack.Critical {
+    t.Fatalf("Attack with a custom die rolling 20: natural was %d (critical %t) instead of 5", attack.Natural, attack.Critical)
+  }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "gorm.io/gorm"
+)
+
+/* A named range of results, ex. 7-9 is a weak hit.
+ * Open-ended bands use math.MinInt or math.MaxInt as their Min or Max.
+ */
+type Band struct {
+  Name string
+  Min int
+  Max int
+}
+
+/* A set of bands defined by a server, stored as its definition,
+ * ex. "10+ Strong hit; 7-9 Weak hit; 6- Miss".
+ */
+type BandSet struct {
+  gorm.Model
+  Guild string
+  Name string
+  Definition string
+}
+
+// Band sets every server can use, by name
+var bandPresets = map[string]string{
+  "pbta": "10+ Strong hit; 7-9 Weak hit; 6- Miss",
+}
+
+// Matches a single band in a definition, ex. "7-9 Weak hit", "10+ Strong hit" or "6- Miss"
+var bandPattern = regexp.MustCompile(`^(-?\d+)\s*(?:(\+)|-\s*(-?\d+)|(-))?\s*:?\s+(\S.*)$`)
+
+/* Parses a definition of bands, separated by semicolons.
+ * Each band is a range followed by its name: N+ for N or more,
+ * N- for N or less, A-B for A to B, or just N.
+ */
+func ParseBands(definition string) ([]Band, error) {
+  bands := []Band{}
+  for _, part := range strings.Split(definition, ";") {
+    part = strings.TrimSpace(part)
+    if part == "" {
+      continue
+    }
+
+    match := bandPattern.FindStringSubmatch(part)
+    if match == nil {
+      return nil, errors.New(fmt.Sprintf("'%s' is not a band. Write a range followed by a name, ex. 7-9 Weak hit", part))
+    }
+    low, err := strconv.Atoi(match[1])
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("%s is too large", match[1]))
+    }
+
+    band := Band{Name: strings.TrimSpace(match[5]), Min: low, Max: low}
+    switch {
+    case match[2] != "":
+      band.Max = math.MaxInt
+    case match[4] != "":
+      band.Min = math.MinInt
+    case match[3] != "":
+      band.Max, err = strconv.Atoi(match[3])
+      if err != nil {
+        return nil, errors.New(fmt.Sprintf("%s is too large", match[3]))
+      }
+      if band.Max < band.Min {
+        return nil, errors.New(fmt.Sprintf("The band '%s' ends before it starts", part))
+      }
+    }
+    bands = append(bands, band)
+  }
+
+  if len(bands) == 0 {
+    return nil, errors.New("There must be at least one band")
+  }
+  return bands, nil
+}
+
+/* Finds the band a result falls into. When bands overlap, the first
+ * one listed wins.
+ */
+func ClassifyResult(bands []Band, result int) (Band, bool) {
+  for _, band := range bands {
+    if result >= band.Min && result <= band.Max {
+      return band, true
+    }
+  }
+  return Band{}, false
+}
+
+/* Describes the range a band covers, ex. 10+, 7-9 or 6-
+ */
+func (b Band) Range() string {
+  switch {
+  case b.Min == b.Max:
+    return fmt.Sprintf("%d", b.Min)
+  case b.Max == math.MaxInt:
+    return fmt.Sprintf("%d+", b.Min)
+  case b.Min == math.MinInt:
+    return fmt.Sprintf("%d-", b.Max)
+  }
+  return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+/* Loads the bands with the given name, either one of the presets or
+ * a set defined by the server.
+ */
+func LoadBands(guild string, name string) ([]Band, error) {
+  if definition, ok := bandPresets[strings.ToLower(name)]; ok {
+    return ParseBands(definition)
+  }
+
+  set, err := FindBandSet(guild, name)
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("No bands with the name '%s' were found.", name))
+  }
+  return ParseBands(set.Definition)
+}
+
+func FindBandSet(guild string, name string) (*BandSet, error) {
+  var set BandSet
+
+  result := db.Where("Guild = ? AND Name = ?", guild, name).First(&set)
+  if result.Error != nil {
+    if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+      return nil, errors.New("No rows found")
+    }
+    return nil, errors.New("Database error")
+  }
+
+  return &set, nil
+}
+
+func MakeBandSet(set *BandSet) {
+  db.Create(set)
+}
+
+func DeleteBandSet(set *BandSet) {
+  db.Delete(set)
+}
+
+func ListBandSets(guild string) ([]BandSet, error) {
+  var sets []BandSet
+  result := db.Where("Guild = ?", guild).Order("Name").Find(&sets)
+  if result.Error != nil {
+    return nil, errors.New("Database error (possibly no rows found)")
+  }
+  return sets, nil
+}
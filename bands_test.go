@@ -0,0 +1,69 @@
+package main
+
+import (
+  "math"
+  "slices"
+  "testing"
+)
+
+/* Test parsing band definitions */
+func TestParseBands(t *testing.T) {
+  bands, err := ParseBands("10+ Strong hit; 7-9 Weak hit; 6- Miss")
+  if err != nil {
+    t.Fatalf("Parsing the PbtA bands failed with error: %s", err)
+  }
+  expected := []Band{{"Strong hit", 10, math.MaxInt}, {"Weak hit", 7, 9}, {"Miss", math.MinInt, 6}}
+  if !slices.Equal(bands, expected) {
+    t.Fatalf("PbtA bands were %v instead of %v", bands, expected)
+  }
+
+  bands, err = ParseBands(" -3 - -1: Bad ; 0 Nothing;1+ Good;")
+  if err != nil {
+    t.Fatalf("Parsing bands with negative numbers failed with error: %s", err)
+  }
+  expected = []Band{{"Bad", -3, -1}, {"Nothing", 0, 0}, {"Good", 1, math.MaxInt}}
+  if !slices.Equal(bands, expected) {
+    t.Fatalf("Bands were %v instead of %v", bands, expected)
+  }
+
+  for _, definition := range []string{"", " ; ", "Strong hit", "10+", "9-7 Backwards", "99999999999999999999+ Huge"} {
+    if _, err := ParseBands(definition); err == nil {
+      t.Fatalf("Parsing bands %q should have failed", definition)
+    }
+  }
+}
+
+/* Test sorting results into the built-in PbtA bands */
+func TestClassifyResult(t *testing.T) {
+  bands, err := LoadBands("", "PbtA")
+  if err != nil {
+    t.Fatalf("Loading the PbtA bands failed with error: %s", err)
+  }
+
+  cases := map[int]string{12: "Strong hit", 10: "Strong hit", 9: "Weak hit", 7: "Weak hit", 6: "Miss", -2: "Miss"}
+  for result, expected := range cases {
+    band, ok := ClassifyResult(bands, result)
+    if !ok || band.Name != expected {
+      t.Fatalf("A result of %d was classified as %q instead of %q", result, band.Name, expected)
+    }
+  }
+
+  if _, ok := ClassifyResult([]Band{{"Middle", 5, 6}}, 7); ok {
+    t.Fatalf("A result outside every band should not be classified")
+  }
+}
+
+/* Test describing the range of a band */
+func TestBandRange(t *testing.T) {
+  cases := map[string]Band{
+    "10+": {"", 10, math.MaxInt},
+    "6-": {"", math.MinInt, 6},
+    "7-9": {"", 7, 9},
+    "4": {"", 4, 4},
+  }
+  for expected, band := range cases {
+    if band.Range() != expected {
+      t.Fatalf("Band %v had range %q instead of %q", band, band.Range(), expected)
+    }
+  }
+}
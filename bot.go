@@ -1,52 +1,494 @@
 package main
 
 import (
+  "bytes"
+  "errors"
   "fmt"
   "strings"
-  "strconv"
+  "unicode/utf8"
   
   "os"
   "os/signal"
+  "runtime/debug"
+  "slices"
 
   "github.com/bwmarrin/discordgo"
 )
 
 /* Formats the result of a dice roll in a pretty, human-readable way.
+ * Dice that were dropped by a keep/drop modifier are struck through,
+ * dice that exploded are marked with 💥, and compounded dice are shown
+ * with a leading + to show they were added onto the die before them.
+ * Rerolled dice are struck through and marked with 🔁, followed by
+ * the die that replaced them. When counting successes, dice that
+ * succeeded are marked with ✅ and dice that failed with ❌.
+ * If any part of the roll was labelled, each label's subtotal follows,
+ * and if any custom dice had named faces, a tally of them.
  */
-func formatRollResult(expression string, result int, rolls []DiceRoll) string {
-  rollResults := ""
-  for _, r := range rolls {
-    parts := strings.Split(r.Expression, "d")
-    max, _ := strconv.Atoi(parts[1])
+func formatRollResult(expression string, result int, rolls []DiceRoll, labels []LabelTotal) string {
+  return fmt.Sprintf(
+    "You asked me to roll: `%s`\nYou rolled a **%d**!\n> *ROLL RESULTS*\n%s%s%s",
+    expression,
+    result,
+    formatDiceLines(rolls),
+    formatLabelTotals(result, labels),
+    formatTally(rolls),
+  )
+}
+
+/* Formats a tally of the named faces rolled on custom dice on a
+ * quoted line. Empty if there weren't any.
+ */
+func formatTally(rolls []DiceRoll) string {
+  tally := formatSymbolCounts(nil, TallySymbols(rolls))
+  if tally == "" {
+    return ""
+  }
+  return fmt.Sprintf("> 🔣 %s\n", tally)
+}
+
+/* Formats the subtotal of each label in a roll on a quoted line,
+ * along with whatever wasn't labelled. Empty if nothing was labelled.
+ */
+func formatLabelTotals(result int, labels []LabelTotal) string {
+  if len(labels) == 0 {
+    return ""
+  }
+
+  totals := []string{}
+  unlabelled := result
+  for _, l := range labels {
+    totals = append(totals, fmt.Sprintf("%s **%d**", l.Label, l.Total))
+    unlabelled -= l.Total
+  }
+  if unlabelled != 0 {
+    totals = append(totals, fmt.Sprintf("unlabelled **%d**", unlabelled))
+  }
+  return fmt.Sprintf("> 🏷️ %s\n", strings.Join(totals, " | "))
+}
+
+/* Formats a roll that was repeated, with each row showing its result
+ * followed by its own dice, in the same style as formatRollResult.
+ */
+func formatRepeatedRollResult(expression string, rows []RollRow) string {
+  results, total, rowResults := formatRowLines(rows)
+  header := fmt.Sprintf(
+    "You asked me to roll: `%s`\nYou rolled %s! (Total: %d)\n> *ROLL RESULTS*\n",
+    expression,
+    results,
+    total,
+  )
+  // Many rows of many dice won't fit in one message, so leave some rows'
+  // dice out rather than failing to send anything
+  return header + truncateLines(rowResults, maxMessageLength - utf8.RuneCountInString(header))
+}
+
+// Discord's limit on the length of a message, in characters
+const maxMessageLength = 2000
+
+/* Shortens text made up of lines to fit in the given number of
+ * characters, keeping as many whole lines from the start as fit and
+ * noting how many were left out.
+ */
+func truncateLines(text string, limit int) string {
+  if utf8.RuneCountInString(text) <= limit {
+    return text
+  }
+
+  lines := strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n")
+  kept := ""
+  for n, line := range lines {
+    note := fmt.Sprintf("\n> *…and %d more lines not shown*\n", len(lines) - n)
+    if utf8.RuneCountInString(kept + line + note) > limit {
+      return strings.TrimSuffix(kept, "\n") + note
+    }
+    kept += line
+  }
+  return kept
+}
+
+/* Formats several rolls made together, each as its own section
+ * headed by its label, in the same style as formatRollResult.
+ */
+func formatSections(input string, sections []rolledSection) string {
+  message := formatSectionLines(input, sections, true)
+  if utf8.RuneCountInString(message) > maxMessageLength {
+    // Leave out the dice so every roll's result still fits
+    message = truncateLines(formatSectionLines(input, sections, false), maxMessageLength)
+  }
+  return message
+}
+
+/* Formats each section for formatSections, with or without its dice.
+ */
+func formatSectionLines(input string, sections []rolledSection, withDice bool) string {
+  message := fmt.Sprintf("You asked me to roll: `%s`\n", input)
+  for _, section := range sections {
+    header := fmt.Sprintf("`%s`", section.Expression)
+    if section.Label != "" {
+      header = fmt.Sprintf("**%s** %s", section.Label, header)
+    }
 
-    if max <= 2 {
-      rollResults += fmt.Sprintf("> 🎲 **%s** %v\n", r.Expression, r.Results)
+    if len(section.Rows) == 1 {
+      row := section.Rows[0]
+      message += fmt.Sprintf("%s: **%d**\n", header, row.Result)
+      if withDice {
+        message += formatDiceLines(row.Rolls) + formatLabelTotals(row.Result, row.Labels) + formatTally(row.Rolls)
+      }
     } else {
-      resultsDisplay := []string{}
-      for _, result := range r.Results {
-        if result == 1 {
-          resultsDisplay = append(resultsDisplay, fmt.Sprintf("🔻**%d**", result))
-        } else if result == max {
-          resultsDisplay = append(resultsDisplay, fmt.Sprintf("🔺**%d**", result))
-        } else {
-          resultsDisplay = append(resultsDisplay, fmt.Sprintf("%d", result))
-        }
+      results, total, rowResults := formatRowLines(section.Rows)
+      message += fmt.Sprintf("%s: %s (Total: %d)\n", header, results, total)
+      if withDice {
+        message += rowResults
       }
-      rollResults += fmt.Sprintf("> 🎲 **%s** %v\n", r.Expression, resultsDisplay)
     }
   }
+  return message
+}
+
+/* Formats each DiceRoll on its own quoted line.
+ */
+func formatDiceLines(rolls []DiceRoll) string {
+  lines := ""
+  for _, r := range rolls {
+    lines += fmt.Sprintf("> %s\n", formatDiceRoll(r))
+  }
+  return lines
+}
+
+/* Formats the rows of a repeated roll, returning a list of their
+ * results, their total, and a quoted line for each row with its dice.
+ */
+func formatRowLines(rows []RollRow) (string, int, string) {
+  results := []string{}
+  total := 0
+  lines := ""
+  for _, row := range rows {
+    results = append(results, fmt.Sprintf("**%d**", row.Result))
+    total += row.Result
+
+    dice := []string{}
+    for _, r := range row.Rolls {
+      dice = append(dice, formatDiceRoll(r))
+    }
+    lines += fmt.Sprintf("> **%d** ⬅️ %s\n", row.Result, strings.Join(dice, " "))
+  }
+  return strings.Join(results, ", "), total, lines
+}
+
+/* Formats a single DiceRoll, marking each die as described
+ * in formatRollResult.
+ */
+func formatDiceRoll(r DiceRoll) string {
+  resultsDisplay := []string{}
+  for i, result := range r.Results {
+    display := fmt.Sprintf("%d", result)
+    if r.Faces != nil {
+      display = fmt.Sprintf("[%s]", r.Faces[i].Text)
+    } else if r.Fate {
+      display = fmt.Sprintf("[%s]", fateSymbol(result))
+    } else if r.Sides > 2 {
+      if result == 1 {
+        display = fmt.Sprintf("🔻**%d**", result)
+      } else if result == r.Sides {
+        display = fmt.Sprintf("🔺**%d**", result)
+      }
+    }
+    if i < len(r.Flags) {
+      if r.Flags[i] & DieCompounded != 0 {
+        display = "+" + display
+      }
+      if r.Flags[i] & DieExploded != 0 {
+        display += "💥"
+      }
+      if r.Flags[i] & DieDropped != 0 {
+        display = fmt.Sprintf("~~%s~~", display)
+      }
+      if r.Flags[i] & DieRerolled != 0 {
+        display = fmt.Sprintf("~~%s~~🔁", display)
+      }
+      if r.Flags[i] & DieSuccess != 0 {
+        display += "✅"
+      }
+      if r.Flags[i] & DieFailure != 0 {
+        display += "❌"
+      }
+    }
+    resultsDisplay = append(resultsDisplay, display)
+  }
+  dice := fmt.Sprint(resultsDisplay)
+  if r.Fate || r.Faces != nil {
+    dice = strings.Join(resultsDisplay, " ")
+  }
+  if r.Label != "" {
+    return fmt.Sprintf("🎲 **%s** *%s* %s", r.Expression, r.Label, dice)
+  }
+  return fmt.Sprintf("🎲 **%s** %s", r.Expression, dice)
+}
+
+/* Finds the column an error from ParseExpression points at, if any.
+ */
+func errorColumn(err error) (int, bool) {
+  var parseError *ParseError
+  var divisionError *DivisionByZeroError
+  var overflowError *OverflowError
+  switch {
+  case errors.As(err, &parseError):
+    return parseError.Column, true
+  case errors.As(err, &divisionError):
+    return divisionError.Column, true
+  case errors.As(err, &overflowError):
+    return overflowError.Column, true
+  }
+  return 0, false
+}
+
+/* Formats the result of an attack, showing the to-hit roll with its
+ * natural d20, whether it was a critical hit or fumble, and the damage.
+ */
+func formatAttack(attack *AttackResult, damage string) string {
+  message := fmt.Sprintf(
+    "⚔️ To hit: `%s` → **%d** (natural %d)\n%s",
+    attack.ToHitExpression,
+    attack.ToHit.Result,
+    attack.Natural,
+    formatDiceLines(attack.ToHit.Rolls),
+  )
+  if attack.Fumble {
+    return message + "💀 **Natural 1, fumble!** The attack misses.\n"
+  }
+  if attack.Critical {
+    message += "💥 **Critical hit!** The damage dice are doubled.\n"
+  }
+  return message + fmt.Sprintf(
+    "🩸 Damage: `%s` → **%d**\n%s%s",
+    damage,
+    attack.Damage.Result,
+    formatDiceLines(attack.Damage.Rolls),
+    formatLabelTotals(attack.Damage.Result, attack.Damage.Labels),
+  )
+}
+
+// How each Call of Cthulhu success level is shown
+var cocLevelNames = map[string]string{
+  CocCritical: "🌟 **Critical success!**",
+  CocExtreme: "✨ **Extreme success!**",
+  CocHard: "✅ **Hard success!**",
+  CocRegular: "✅ **Regular success.**",
+  CocFailure: "❌ **Failure.**",
+  CocFumble: "💀 **Fumble!**",
+}
+
+/* Formats a Call of Cthulhu roll, showing the tens and units dice
+ * separately, with any tens dice that weren't used struck through.
+ */
+func formatCoc(r *CocResult, modifier int) string {
+  dice := ""
+  if modifier != 0 {
+    kind, count, noun := "bonus", modifier, "die"
+    if modifier < 0 {
+      kind, count = "penalty", -modifier
+    }
+    if count > 1 {
+      noun = "dice"
+    }
+    dice = fmt.Sprintf(" with %d %s %s", count, kind, noun)
+  }
+
+  tens := []string{}
+  for i, t := range r.Tens {
+    display := fmt.Sprintf("%02d", t)
+    if i != r.Kept {
+      display = fmt.Sprintf("~~%s~~", display)
+    }
+    tens = append(tens, display)
+  }
+
   return fmt.Sprintf(
-    "You asked me to roll: `%s`\nYou rolled a **%d**!\n> *ROLL RESULTS*\n%s",
+    "🐙 Rolling against a skill of **%d**%s\n> Tens: %v | Units: [%d]\n> You rolled **%d**! %s\n> Regular ≤ %d | Hard ≤ %d | Extreme ≤ %d\n",
+    r.Skill,
+    dice,
+    tens,
+    r.Units,
+    r.Result,
+    cocLevelNames[r.Level],
+    r.Skill,
+    r.Skill / 2,
+    r.Skill / 5,
+  )
+}
+
+// How each Forged in the Dark outcome is shown
+var fitdOutcomeNames = map[string]string{
+  FitdCritical: "🌟 **Critical success!**",
+  FitdSuccess: "✅ **Full success!**",
+  FitdPartial: "⚠️ **Partial success.**",
+  FitdBad: "❌ **Bad outcome.**",
+}
+
+/* Formats a Forged in the Dark action roll, with its position and
+ * effect, and what the outcome means.
+ */
+func formatFitd(r *FitdResult, position string, effect string) string {
+  dice := fmt.Sprintf("%d dice", r.Dice)
+  if r.Dice == 0 {
+    dice = "0 dice (rolling 2 and keeping the lowest)"
+  }
+  message := fmt.Sprintf(
+    "🗡️ Action roll with %s, **%s** position and **%s** effect\n%s> You rolled a **%d**! %s\n> %s\n",
+    dice,
+    position,
+    effect,
+    formatDiceLines(r.Rolls),
+    r.Result,
+    fitdOutcomeNames[r.Outcome],
+    fitdOutcomeText(r.Outcome, position),
+  )
+  if increased := fitdEffect(r.Outcome, effect); increased != effect {
+    message += fmt.Sprintf("> Effect is now **%s**.\n", increased)
+  }
+  return message
+}
+
+/* Formats a tally of symbols, ex. "2 success, 1 advantage", listing
+ * them in the given order and any others alphabetically after.
+ */
+func formatSymbolCounts(order []string, counts map[string]int) string {
+  symbols := []string{}
+  for symbol := range counts {
+    if !slices.Contains(order, symbol) {
+      symbols = append(symbols, symbol)
+    }
+  }
+  slices.Sort(symbols)
+
+  parts := []string{}
+  for _, symbol := range append(slices.Clone(order), symbols...) {
+    if counts[symbol] > 0 {
+      parts = append(parts, fmt.Sprintf("%d %s", counts[symbol], symbol))
+    }
+  }
+  return strings.Join(parts, ", ")
+}
+
+/* Formats the faces that came up on dice with defined faces, ex.
+ * [success+advantage] [blank] [2]
+ */
+func formatDieFaces(faces []DieFace) string {
+  shown := []string{}
+  for _, face := range faces {
+    shown = append(shown, fmt.Sprintf("[%s]", face.Text))
+  }
+  return strings.Join(shown, " ")
+}
+
+/* Formats a roll of a pool of symbol dice, with the faces that came up
+ * and the symbols left once they've cancelled each other out.
+ */
+func formatSymbolRoll(system SymbolSystem, pool string, r *SymbolResult) string {
+  message := fmt.Sprintf("✨ Rolled `%s`\n", pool)
+  for i, roll := range r.Rolls {
+    message += fmt.Sprintf("🎲 **%s** %s\n", roll.Expression, formatDieFaces(r.Faces[i]))
+  }
+
+  net := formatSymbolCounts(system.Symbols, r.Net)
+  if net == "" {
+    net = "nothing, everything cancelled out"
+  }
+  message += fmt.Sprintf("> Net: **%s**\n", net)
+  if r.Checked {
+    if r.Succeeded {
+      message += "> ✅ **The check succeeds!**\n"
+    } else {
+      message += "> ❌ **The check fails.**\n"
+    }
+  }
+  return message
+}
+
+/* Formats the odds of an expression in a human-readable way.
+ * If target is given, also shows the chance of rolling at least that.
+ */
+func formatOdds(expression string, d *Distribution, target *int) string {
+  message := fmt.Sprintf(
+    "📊 Odds for `%s`\n> Average: **%.2f** (standard deviation %.2f)\n> Lowest: **%d** | Highest: **%d**\n",
     expression,
-    result,
-    rollResults,
+    d.Mean(),
+    d.StdDev(),
+    d.Min,
+    d.Max(),
+  )
+  if target != nil {
+    message += fmt.Sprintf("> Chance of %d or higher: **%.2f%%**\n", *target, d.AtLeast(*target) * 100)
+  }
+  return message
+}
+
+/* Formats the results of a simulation in a human-readable way.
+ * If target is given, also shows how often at least that was rolled.
+ */
+func formatSimulation(expression string, r *SimulationResult, target *int) string {
+  message := fmt.Sprintf(
+    "🎰 Simulated `%s` %d times\n> Average: **%.2f** (standard deviation %.2f)\n> Lowest: **%d** | Highest: **%d**\n> Percentiles: 5th **%d** | 25th **%d** | median **%d** | 75th **%d** | 95th **%d**\n",
+    expression,
+    r.Iterations,
+    r.Mean(),
+    r.StdDev(),
+    r.Min(),
+    r.Max(),
+    r.Percentile(5),
+    r.Percentile(25),
+    r.Percentile(50),
+    r.Percentile(75),
+    r.Percentile(95),
   )
+  if target != nil {
+    message += fmt.Sprintf("> Rolled %d or higher: **%.2f%%**\n", *target, r.AtLeast(*target) * 100)
+  }
+  if r.TimedOut {
+    message += "⏱️ The simulation ran out of time, so it stopped early.\n"
+  }
+  return message
+}
+
+/* Formats a comparison of several expressions' odds, with a legend
+ * and the average of each. The chart itself is added separately.
+ */
+func formatComparison(series []chartSeries) string {
+  message := "📊 Comparing odds\n"
+  for i, s := range series {
+    message += fmt.Sprintf(
+      "> %s %s `%s` | Average: **%.2f** | Range: %d to %d\n",
+      chartLegend[i],
+      chartSymbols[i],
+      s.Expression,
+      s.Distribution.Mean(),
+      s.Distribution.Min,
+      s.Distribution.Max(),
+    )
+  }
+  return message
+}
+
+/* Formats an error from parsing an expression.
+ * If the error knows which column failed, the expression is shown
+ * with a ^ pointing at that column.
+ */
+func formatParseError(expression string, err error) string {
+  if column, ok := errorColumn(err); ok {
+    pointer := strings.Repeat(" ", column-1) + "^"
+    return fmt.Sprintf("**Uh-oh!** Error occurred parsing:\n```\n%s\n%s\n```%s", expression, pointer, err)
+  }
+  return fmt.Sprintf("**Uh-oh!** Error occurred parsing: %s \n%s", expression, err)
 }
 
 /* Sends a message to Discord. 
  * Used for the bot to respond to slash commands. 
+ * Messages over Discord's length limit are shortened to fit, since
+ * Discord would otherwise refuse to send them at all.
  */
 func sendDiscordMessage(s* discordgo.Session, i *discordgo.InteractionCreate, message string) {
+  message = truncateLines(message, maxMessageLength)
   s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
     Type: discordgo.InteractionResponseChannelMessageWithSource,
     Data: &discordgo.InteractionResponseData{
@@ -58,16 +500,225 @@ func sendDiscordMessage(s* discordgo.Session, i *discordgo.InteractionCreate, me
   })
 }
 
+/* Sends a message to Discord with a file attached.
+ */
+func sendDiscordFile(s *discordgo.Session, i *discordgo.InteractionCreate, message string, file *discordgo.File) {
+  s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+    Type: discordgo.InteractionResponseChannelMessageWithSource,
+    Data: &discordgo.InteractionResponseData{
+      Content: message,
+      Files: []*discordgo.File{file},
+      AllowedMentions: &discordgo.MessageAllowedMentions{
+        Parse: []discordgo.AllowedMentionType{},
+      },
+    },
+  })
+}
+
+/* Finds the ID of the user who sent an interaction, whether it was
+ * sent in a server or a direct message.
+ */
+func interactionUserID(i *discordgo.InteractionCreate) string {
+  if i.Interaction.Member != nil && i.Interaction.Member.User != nil {
+    return i.Interaction.Member.User.ID
+  }
+  if i.Interaction.User != nil {
+    return i.Interaction.User.ID
+  }
+  return ""
+}
+
+/* Signs a receipt for a roll and adds it to the end of the message,
+ * so the roll can be checked later with /verify-roll.
+ */
+func appendReceipt(message string, i *discordgo.InteractionCreate, expression string, rows []RollRow) string {
+  return appendFooter(message, formatReceipt(i, expression, rows))
+}
+
+/* Signs a receipt for a roll, as a line to add to the end of a message.
+ */
+func formatReceipt(i *discordgo.InteractionCreate, expression string, rows []RollRow) string {
+  key, err := receiptKey()
+  if err != nil {
+    return fmt.Sprintf("\n⚠️ %s", err)
+  }
+
+  receipt := NewRepeatedRollReceipt(expression, rows, i.Interaction.GuildID, interactionUserID(i))
+  code, err := SignReceipt(receipt, key)
+  if err != nil {
+    return fmt.Sprintf("\n⚠️ Unable to sign receipt: %s", err)
+  }
+  // Leave room for at least the results of the roll
+  receiptLine := fmt.Sprintf("\n🧾 Receipt: `%s`", code)
+  if utf8.RuneCountInString(receiptLine) > maxMessageLength / 2 {
+    receiptLine = "\n⚠️ This roll has too many dice to fit a receipt in one message."
+  }
+  return receiptLine
+}
+
+/* Adds a footer to the end of a message, shortening the rest of the
+ * message rather than cutting off the footer.
+ */
+func appendFooter(message string, footer string) string {
+  return truncateLines(message, maxMessageLength - utf8.RuneCountInString(footer)) + footer
+}
+
+/* One of several rolls made together, along with its results.
+ */
+type rolledSection struct {
+  LabelledRoll
+  Rows []RollRow
+}
+
+/* Rolls every semicolon-separated roll in the input, sorting the rows
+ * of any repeated rolls if asked to ("ascending" or "descending").
+ * If a roll fails, its expression is returned along with the error.
+ */
+func rollSections(input string, options EvalOptions, sort string) ([]rolledSection, string, error) {
+  rolls := SplitRolls(input)
+  if len(rolls) == 0 {
+    // Nothing to roll, so let the parser explain what's missing
+    rolls = []LabelledRoll{{Expression: input}}
+  }
+  if len(rolls) > maxRollSections {
+    return nil, input, errors.New(fmt.Sprintf("You can make at most %d rolls at once", maxRollSections))
+  }
+
+  sections := []rolledSection{}
+  for _, roll := range rolls {
+    rows, err := ParseRepeatedExpression(roll.Expression, options)
+    if err != nil {
+      return nil, roll.Expression, err
+    }
+    if sort != "" {
+      SortRows(rows, sort == "descending")
+    }
+    sections = append(sections, rolledSection{LabelledRoll: roll, Rows: rows})
+  }
+  return sections, "", nil
+}
+
+/* Formats the results of rollSections, using the simpler formats
+ * when only one roll without a label was made.
+ */
+func formatRolls(input string, sections []rolledSection) string {
+  if len(sections) > 1 || sections[0].Label != "" {
+    return formatSections(input, sections)
+  }
+  rows := sections[0].Rows
+  if len(rows) == 1 {
+    return formatRollResult(input, rows[0].Result, rows[0].Rolls, rows[0].Labels)
+  }
+  return formatRepeatedRollResult(input, rows)
+}
+
+/* Names the result of every roll on the Fate ladder, on its own line.
+ */
+func formatFateLadder(sections []rolledSection) string {
+  rungs := []string{}
+  for _, row := range sectionRows(sections) {
+    rungs = append(rungs, fmt.Sprintf("**%s**", FateLadder(row.Result)))
+  }
+  return fmt.Sprintf("🪜 Fate ladder: %s\n", strings.Join(rungs, ", "))
+}
+
+/* Shows which band the result of every roll falls into, one per line.
+ */
+func formatBands(sections []rolledSection, bands []Band) string {
+  message := ""
+  for _, row := range sectionRows(sections) {
+    band, ok := ClassifyResult(bands, row.Result)
+    if ok {
+      message += fmt.Sprintf("📜 **%d** → **%s** (%s)\n", row.Result, band.Name, band.Range())
+    } else {
+      message += fmt.Sprintf("📜 **%d** doesn't fall into any band\n", row.Result)
+    }
+  }
+  return message
+}
+
+/* Lists bands along with the range each one covers.
+ */
+func formatBandList(bands []Band) string {
+  list := []string{}
+  for _, band := range bands {
+    list = append(list, fmt.Sprintf("%s %s", band.Range(), band.Name))
+  }
+  return strings.Join(list, "; ")
+}
+
+/* Collects the rows of every section, in order.
+ */
+func sectionRows(sections []rolledSection) []RollRow {
+  rows := []RollRow{}
+  for _, section := range sections {
+    rows = append(rows, section.Rows...)
+  }
+  return rows
+}
+
+/* Runs a command handler, recovering from any panic inside it so
+ * that one bad command cannot take down the whole bot.
+ */
+func runCommandHandler(h func(s *discordgo.Session, i *discordgo.InteractionCreate), s *discordgo.Session, i *discordgo.InteractionCreate) {
+  defer func() {
+    if r := recover(); r != nil {
+      fmt.Printf("Recovered from panic in /%s: %v\n%s\n", i.ApplicationCommandData().Name, r, debug.Stack())
+      sendDiscordMessage(s, i, "**Uh-oh!** Something went wrong running that command. Please contact bot admin for support.")
+    }
+  }()
+
+  h(s, i)
+}
+
+// The fewest rolls /simulate may be asked for
+var minSimulationIterations = 1.0
+
+// The lowest natural roll /attack may be told is a critical hit
+var minCritOn = 2.0
+
+// The lowest skill value /coc may roll against
+var minCocSkill = 1.0
+
+// The fewest dice /fitd may roll
+var minFitdDice = 0.0
+
+/* Lists names as choices for a command option.
+ */
+func fitdChoices(names []string) []*discordgo.ApplicationCommandOptionChoice {
+  choices := []*discordgo.ApplicationCommandOptionChoice{}
+  for _, name := range names {
+    choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+  }
+  return choices
+}
+
 /* Sets up and runs a Discord bot to respond to slash commands for rolling dice.
  * The following commands are supported: 
- * - /roll <expression> | rolls the given expression
+ * - /roll <expression> [receipt] [fate-ladder] [bands] [sort] | rolls the given expression
  * - /make-macro <name> <expression> | creates a macro with the given name
  * - /roll-macro <name> <arguments> | rolls the macro with the given name using given arguments
  * - /list-macros | lists all macros available to the server
  * - /view-macro <name> | views the macro with the given name
  * - /delete-macro <name> | deletes the macro with the given name
  * - /edit-macro <name> <expression> | replaces existing macro with given expression
- * - /help-me-roll | displays help/usage information
+ * - /make-bands <name> <bands> | creates a set of named result bands for /roll
+ * - /list-bands | lists all bands available to the server
+ * - /delete-bands <name> | deletes the bands with the given name
+ * - /make-die <name> <faces> | creates a custom die, rolled in expressions as d{name}
+ * - /list-dice | lists all custom dice made in the server
+ * - /delete-die <name> | deletes the custom die with the given name
+ * - /set-rounding <mode> | sets how division is rounded in the server
+ * - /set-secure-rolls <enabled> | turns secure rolls with receipts on or off
+ * - /verify-roll <receipt> | checks a signed roll receipt
+ * - /attack <to-hit> <damage> [crit-on] | rolls an attack, doubling the damage dice on a critical hit
+ * - /coc <skill> [bonus] [penalty] | makes a Call of Cthulhu percentile roll against a skill
+ * - /fitd <dice> [position] [effect] | makes a Forged in the Dark action roll
+ * - /genesys <pool> | rolls a pool of Genesys or Star Wars narrative dice
+ * - /odds <expression> [target] | works out the exact odds of an expression
+ * - /simulate <expression> [iterations] [target] | rolls an expression many times and summarises the results
+ * - /compare-rolls <first> <second> [third] [fourth] [png] | charts the odds of several expressions
+ * - /help-me-roll [topic] | displays help/usage information
  */
 func RunBot() {
   // Set up the discord bot
@@ -89,116 +740,480 @@ func RunBot() {
 
   // Set up commands
   fmt.Println("Registering commands...")
+  // Server settings can only be changed by members who can manage the server
+  var manageServer int64 = discordgo.PermissionManageServer
   commands := []*discordgo.ApplicationCommand{
     {
-      Name: "roll",
-      Description: "Roll some dice",
+      Name: "roll",
+      Description: "Roll some dice",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "expression",
+          Description: "Your expression with dice notation",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionBoolean,
+          Name: "receipt",
+          Description: "Attach a signed receipt that can be checked with /verify-roll",
+          Required: false,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionBoolean,
+          Name: "fate-ladder",
+          Description: "Name the result on the Fate ladder, ex. Great (+4)",
+          Required: false,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "bands",
+          Description: "Name the result with a set of bands, ex. pbta for strong hit, weak hit or miss",
+          Required: false,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "sort",
+          Description: "Sort the results of a repeated roll, ex. 6x 4d6kh3",
+          Required: false,
+          Choices: []*discordgo.ApplicationCommandOptionChoice{
+            {Name: "ascending", Value: "ascending"},
+            {Name: "descending", Value: "descending"},
+          },
+        },
+      },
+    },
+    {
+      Name: "make-macro",
+      Description: "Create a macro",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the macro",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "expression",
+          Description: "The macro expression, using A, B, C etc for inputs to the macro",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "roll-macro",
+      Description: "Roll one of your custom macros",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the macro you want to roll",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "inputs",
+          Description: "The inputs to the macro, separated by spaces",
+          Required: false,
+        },
+      },
+    },
+    {
+      Name: "list-macros",
+      Description: "List all macros available to the server",
+    },
+    {
+      Name: "view-macro",
+      Description: "View an existing macro",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the macro",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "delete-macro",
+      Description: "Delete an existing macro",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the macro",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "edit-macro",
+      Description: "Create a macro",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the macro",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "expression",
+          Description: "The macro expression, using A, B, C etc for inputs to the macro",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "make-bands",
+      Description: "Create a set of named result bands",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the bands",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "bands",
+          Description: "Each range and its name, separated by semicolons, ex. 10+ Strong hit; 7-9 Weak hit; 6- Miss",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "list-bands",
+      Description: "List all bands available to the server",
+    },
+    {
+      Name: "delete-bands",
+      Description: "Delete an existing set of bands",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the bands",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "make-die",
+      Description: "Create a custom die with its own faces",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the die, rolled as d{name}",
+          Required: true,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "faces",
+          Description: "Each face, separated by commas, ex. 0, 0, 1, 1, 2, 3 or head, torso, left arm",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "list-dice",
+      Description: "List all custom dice made in the server",
+    },
+    {
+      Name: "delete-die",
+      Description: "Delete an existing custom die",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "name",
+          Description: "The name of the die",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "set-rounding",
+      Description: "Choose how division is rounded in this server",
+      DefaultMemberPermissions: &manageServer,
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "mode",
+          Description: "How to round the result of a division",
+          Required: true,
+          Choices: []*discordgo.ApplicationCommandOptionChoice{
+            {Name: "floor (round down)", Value: "floor"},
+            {Name: "ceil (round up)", Value: "ceil"},
+            {Name: "round (to nearest, halves round up)", Value: "round"},
+            {Name: "truncate (round toward zero)", Value: "truncate"},
+          },
+        },
+      },
+    },
+    {
+      Name: "set-secure-rolls",
+      Description: "Turn secure rolls with signed receipts on or off for this server",
+      DefaultMemberPermissions: &manageServer,
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionBoolean,
+          Name: "enabled",
+          Description: "Whether to use secure rolls",
+          Required: true,
+        },
+      },
+    },
+    {
+      Name: "verify-roll",
+      Description: "Check a roll receipt",
       Options: []*discordgo.ApplicationCommandOption{
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "expression",
-          Description: "Your expression with dice notation",
+          Name: "receipt",
+          Description: "The receipt from the roll",
           Required: true,
         },
       },
     },
     {
-      Name: "make-macro",
-      Description: "Create a macro",
+      Name: "attack",
+      Description: "Roll to hit and then roll damage, with critical hits and fumbles",
       Options: []*discordgo.ApplicationCommandOption{
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "name",
-          Description: "The name of the macro",
+          Name: "to-hit",
+          Description: "Your attack bonus, ex. +7, or a to-hit roll with a d20, ex. 2d20kh1+7",
           Required: true,
         },
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "expression",
-          Description: "The macro expression, using A, B, C etc for inputs to the macro",
+          Name: "damage",
+          Description: "Your damage roll, ex. 2d6+4",
           Required: true,
         },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "crit-on",
+          Description: "The lowest natural roll that is a critical hit, ex. 19 for 19-20 (default 20)",
+          Required: false,
+          MinValue: &minCritOn,
+          MaxValue: 20,
+        },
       },
     },
     {
-      Name: "roll-macro",
-      Description: "Roll one of your custom macros",
+      Name: "coc",
+      Description: "Make a Call of Cthulhu percentile roll against a skill",
       Options: []*discordgo.ApplicationCommandOption{
         {
-          Type: discordgo.ApplicationCommandOptionString,
-          Name: "name",
-          Description: "The name of the macro you want to roll",
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "skill",
+          Description: "Your skill value",
+          Required: true,
+          MinValue: &minCocSkill,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "bonus",
+          Description: "How many bonus dice to roll",
+          Required: false,
+          MaxValue: maxCocDice,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "penalty",
+          Description: "How many penalty dice to roll",
+          Required: false,
+          MaxValue: maxCocDice,
+        },
+      },
+    },
+    {
+      Name: "fitd",
+      Description: "Make a Forged in the Dark action roll",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "dice",
+          Description: "How many dice to roll",
           Required: true,
+          MinValue: &minFitdDice,
+          MaxValue: 20,
         },
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "inputs",
-          Description: "The inputs to the macro, separated by spaces",
+          Name: "position",
+          Description: "Your position (default risky)",
+          Required: false,
+          Choices: fitdChoices(fitdPositions),
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "effect",
+          Description: "Your effect level (default standard)",
           Required: false,
+          Choices: fitdChoices(fitdEffects),
         },
       },
     },
     {
-      Name: "list-macros",
-      Description: "List all macros available to the server",
+      Name: "genesys",
+      Description: "Roll a pool of Genesys or Star Wars narrative dice",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "pool",
+          Description: "The dice to roll, ex. 2a 1p 2d 1s",
+          Required: true,
+        },
+      },
     },
     {
-      Name: "view-macro",
-      Description: "View an existing macro",
+      Name: "odds",
+      Description: "Work out the exact odds of an expression without rolling it",
       Options: []*discordgo.ApplicationCommandOption{
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "name",
-          Description: "The name of the macro",
+          Name: "expression",
+          Description: "Your expression with dice notation",
           Required: true,
         },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "target",
+          Description: "Also show the chance of rolling at least this",
+          Required: false,
+        },
       },
     },
     {
-      Name: "delete-macro",
-      Description: "Delete an existing macro",
+      Name: "simulate",
+      Description: "Roll an expression many times and summarise the results",
       Options: []*discordgo.ApplicationCommandOption{
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "name",
-          Description: "The name of the macro",
+          Name: "expression",
+          Description: "Your expression with dice notation",
           Required: true,
         },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "iterations",
+          Description: "How many times to roll it",
+          Required: false,
+          MinValue: &minSimulationIterations,
+          MaxValue: maxSimulationIterations,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionInteger,
+          Name: "target",
+          Description: "Also show how often at least this was rolled",
+          Required: false,
+        },
       },
     },
     {
-      Name: "edit-macro",
-      Description: "Create a macro",
+      Name: "compare-rolls",
+      Description: "Compare the odds of several expressions on one chart",
       Options: []*discordgo.ApplicationCommandOption{
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "name",
-          Description: "The name of the macro",
+          Name: "first",
+          Description: "The first expression to compare",
           Required: true,
         },
         {
           Type: discordgo.ApplicationCommandOptionString,
-          Name: "expression",
-          Description: "The macro expression, using A, B, C etc for inputs to the macro",
+          Name: "second",
+          Description: "The second expression to compare",
           Required: true,
         },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "third",
+          Description: "A third expression to compare",
+          Required: false,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "fourth",
+          Description: "A fourth expression to compare",
+          Required: false,
+        },
+        {
+          Type: discordgo.ApplicationCommandOptionBoolean,
+          Name: "png",
+          Description: "Draw the chart as an image instead of text",
+          Required: false,
+        },
       },
     },
     {
       Name: "help-me-roll",
       Description: "Shows you how to use the DiceMancer bot",
+      Options: []*discordgo.ApplicationCommandOption{
+        {
+          Type: discordgo.ApplicationCommandOptionString,
+          Name: "topic",
+          Description: "What you want help with",
+          Required: false,
+          Choices: helpTopicChoices(),
+        },
+      },
     },
   }
   commandHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
     "roll": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-      argument := i.ApplicationCommandData().Options[0].StringValue()
-      result, rolls, error := ParseExpression(argument)
+      var argument, sort, bandsName string
+      wantsReceipt := false
+      wantsLadder := false
+      for _, option := range i.ApplicationCommandData().Options {
+        switch option.Name {
+        case "expression":
+          argument = option.StringValue()
+        case "receipt":
+          wantsReceipt = option.BoolValue()
+        case "sort":
+          sort = option.StringValue()
+        case "fate-ladder":
+          wantsLadder = option.BoolValue()
+        case "bands":
+          bandsName = option.StringValue()
+        }
+      }
+      var bands []Band
+      if bandsName != "" {
+        var err error
+        bands, err = LoadBands(i.Interaction.GuildID, bandsName)
+        if err != nil {
+          sendDiscordMessage(s, i, err.Error())
+          return
+        }
+      }
+
+      options := GuildEvalOptions(i.Interaction.GuildID)
+      sections, failed, error := rollSections(argument, options, sort)
 
       if error != nil {
-        sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** Error occurred parsing: %s \n%s", argument, error))
-      } else {
-        sendDiscordMessage(s, i, formatRollResult(argument, result, rolls))
+        sendDiscordMessage(s, i, formatParseError(failed, error))
+        return
+      }
+
+      footer := ""
+      if wantsLadder {
+        footer += formatFateLadder(sections)
+      }
+      if bands != nil {
+        footer += formatBands(sections, bands)
       }
+      // Leave room for at least the results of the roll and the receipt
+      footer = truncateLines(footer, maxMessageLength / 4)
+      if wantsReceipt || options.Roller == secureRoller {
+        footer += formatReceipt(i, argument, sectionRows(sections))
+      }
+      sendDiscordMessage(s, i, appendFooter(formatRolls(argument, sections), footer))
     },
     "make-macro": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
       name := i.ApplicationCommandData().Options[0].StringValue()
@@ -212,7 +1227,7 @@ func RunBot() {
       }
 
       // Validate the macro expression
-      err := ValidateMacro(expression)
+      err := ValidateMacro(expression, GuildEvalOptions(i.Interaction.GuildID))
       if err != nil {
         sendDiscordMessage(s, i, fmt.Sprintf("Invalid macro expression: %s", err))
         return
@@ -238,13 +1253,19 @@ func RunBot() {
       macro, _ := FindMacro(i.Interaction.GuildID, name)
       if macro != nil {
         expression := FillMacro(macro.Expression, arguments)
-        result, rolls, err := ParseExpression(expression)
+        options := GuildEvalOptions(i.Interaction.GuildID)
+        sections, failed, err := rollSections(expression, options, "")
 
         if err != nil {
-          sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** Error occurred parsing: %s \n%s", expression, err))
+          sendDiscordMessage(s, i, formatParseError(failed, err))
           return
         }
-        sendDiscordMessage(s, i, formatRollResult(expression, result, rolls))
+
+        message := formatRolls(expression, sections)
+        if options.Roller == secureRoller {
+          message = appendReceipt(message, i, expression, sectionRows(sections))
+        }
+        sendDiscordMessage(s, i, message)
       } else {
         sendDiscordMessage(s, i, fmt.Sprintf("No macro with the name '%s' was found.", name))
       }
@@ -297,42 +1318,370 @@ func RunBot() {
         sendDiscordMessage(s, i, fmt.Sprintf("No macro with the name '%s' was found.", name))
       }
     },
-    "help-me-roll": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-      helpMessage := `**DiceMancer Bot Available Commands**
+    "make-bands": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      name := i.ApplicationCommandData().Options[0].StringValue()
+      definition := i.ApplicationCommandData().Options[1].StringValue()
+
+      if len(name) < 1 || len(name) > 128 {
+        sendDiscordMessage(s, i, "Band names must be between 1 and 128 characters long.")
+        return
+      }
+      if _, ok := bandPresets[strings.ToLower(name)]; ok {
+        sendDiscordMessage(s, i, fmt.Sprintf("'%s' is already the name of built-in bands.", name))
+        return
+      }
+      existing, _ := FindBandSet(i.Interaction.GuildID, name)
+      if existing != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Bands with the name '%s' already exist.", name))
+        return
+      }
+
+      bands, err := ParseBands(definition)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Invalid bands: %s", err))
+        return
+      }
+
+      MakeBandSet(&BandSet{Guild: i.Interaction.GuildID, Name: name, Definition: definition})
+      sendDiscordMessage(s, i, fmt.Sprintf("Bands '%s' created!\nBands: %s", name, formatBandList(bands)))
+    },
+    "list-bands": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      listMessage := "Built-in bands: \n"
+      for name, definition := range bandPresets {
+        listMessage += fmt.Sprintf("**%s**: %s\n", name, definition)
+      }
+
+      sets, _ := ListBandSets(i.Interaction.GuildID)
+      if len(sets) > 0 {
+        listMessage += "Bands made in this server: \n"
+        for _, set := range sets {
+          listMessage += fmt.Sprintf("**%s**: %s\n", set.Name, set.Definition)
+        }
+      } else {
+        listMessage += "Make your own with the /make-bands command."
+      }
+      sendDiscordMessage(s, i, listMessage)
+    },
+    "delete-bands": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      name := i.ApplicationCommandData().Options[0].StringValue()
+
+      set, _ := FindBandSet(i.Interaction.GuildID, name)
+      if set != nil {
+        DeleteBandSet(set)
+        sendDiscordMessage(s, i, fmt.Sprintf("Bands '%s' were deleted.", name))
+      } else {
+        sendDiscordMessage(s, i, fmt.Sprintf("No bands with the name '%s' were found.", name))
+      }
+    },
+    "make-die": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      name := i.ApplicationCommandData().Options[0].StringValue()
+      definition := i.ApplicationCommandData().Options[1].StringValue()
+
+      if err := ValidateDieName(name); err != nil {
+        sendDiscordMessage(s, i, err.Error())
+        return
+      }
+      existing, _ := FindNamedDie(i.Interaction.GuildID, name)
+      if existing != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("A die with the name '%s' already exists.", name))
+        return
+      }
+
+      faces, err := ParseDieFaces(definition)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Invalid faces: %s", err))
+        return
+      }
+
+      MakeNamedDie(&NamedDie{Guild: i.Interaction.GuildID, Name: name, Faces: definition})
+      sendDiscordMessage(s, i, fmt.Sprintf("Die '%s' created! Roll it with `d{%s}`.\nFaces: %s", name, strings.ToLower(name), formatDieFaces(faces)))
+    },
+    "list-dice": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      dice, _ := ListNamedDice(i.Interaction.GuildID)
+      if len(dice) == 0 {
+        sendDiscordMessage(s, i, "No custom dice have been made in this server. Make one with the /make-die command.")
+        return
+      }
+
+      listMessage := "Custom dice made in this server: \n"
+      for _, die := range dice {
+        listMessage += fmt.Sprintf("**d{%s}**: %s\n", die.Name, die.Faces)
+      }
+      sendDiscordMessage(s, i, listMessage)
+    },
+    "delete-die": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      name := i.ApplicationCommandData().Options[0].StringValue()
+
+      die, _ := FindNamedDie(i.Interaction.GuildID, name)
+      if die != nil {
+        DeleteNamedDie(die)
+        sendDiscordMessage(s, i, fmt.Sprintf("Die '%s' was deleted.", name))
+      } else {
+        sendDiscordMessage(s, i, fmt.Sprintf("No die with the name '%s' was found.", name))
+      }
+    },
+    "set-rounding": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      mode := i.ApplicationCommandData().Options[0].StringValue()
+      if !slices.Contains(roundingModes, mode) {
+        sendDiscordMessage(s, i, fmt.Sprintf("Unknown rounding mode '%s'.", mode))
+        return
+      }
+
+      settings, err := FindGuildSettings(i.Interaction.GuildID)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Unable to load server settings: %s", err))
+        return
+      }
+
+      settings.Rounding = mode
+      SaveGuildSettings(settings)
+      sendDiscordMessage(s, i, fmt.Sprintf("Division will now use '%s' rounding in this server.", mode))
+    },
+    "set-secure-rolls": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      enabled := i.ApplicationCommandData().Options[0].BoolValue()
+
+      settings, err := FindGuildSettings(i.Interaction.GuildID)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Unable to load server settings: %s", err))
+        return
+      }
+
+      settings.SecureRolls = enabled
+      SaveGuildSettings(settings)
+      if enabled {
+        sendDiscordMessage(s, i, "Secure rolls are now on. Every roll will use a cryptographically secure random source and come with a signed receipt.")
+      } else {
+        sendDiscordMessage(s, i, "Secure rolls are now off.")
+      }
+    },
+    "verify-roll": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      code := i.ApplicationCommandData().Options[0].StringValue()
+
+      key, err := receiptKey()
+      if err != nil {
+        sendDiscordMessage(s, i, err.Error())
+        return
+      }
+
+      receipt, err := VerifyReceipt(code, key)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("❌ %s", err))
+        return
+      }
+
+      result := fmt.Sprintf("%d", receipt.Result)
+      if len(receipt.Rows) > 0 {
+        result = strings.Trim(fmt.Sprint(receipt.Rows), "[]")
+      }
+      sendDiscordMessage(s, i, fmt.Sprintf(
+        "✅ This receipt is genuine.\n> <@%s> rolled `%s` and got **%s** on <t:%d:f>\n> Dice: %v\n> Server: %s",
+        receipt.User,
+        receipt.Expression,
+        result,
+        receipt.Timestamp,
+        receipt.Rolls,
+        receipt.Guild,
+      ))
+    },
+    "attack": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      var toHit, damage string
+      critOn := 20
+      for _, option := range i.ApplicationCommandData().Options {
+        switch option.Name {
+        case "to-hit":
+          toHit = option.StringValue()
+        case "damage":
+          damage = option.StringValue()
+        case "crit-on":
+          critOn = int(option.IntValue())
+        }
+      }
+
+      options := GuildEvalOptions(i.Interaction.GuildID)
+      attack, err := RollAttack(toHit, damage, critOn, options)
+      var attackError *AttackError
+      if errors.As(err, &attackError) {
+        sendDiscordMessage(s, i, formatParseError(attackError.Expression, attackError.Err))
+        return
+      }
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** %s", err))
+        return
+      }
+
+      message := formatAttack(attack, damage)
+      if options.Roller == secureRoller {
+        rows := []RollRow{attack.ToHit}
+        if attack.Damage != nil {
+          rows = append(rows, *attack.Damage)
+        }
+        message = appendReceipt(message, i, fmt.Sprintf("Attack: %s; Damage: %s", attack.ToHitExpression, damage), rows)
+      }
+      sendDiscordMessage(s, i, message)
+    },
+    "coc": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      skill, modifier := 0, 0
+      for _, option := range i.ApplicationCommandData().Options {
+        switch option.Name {
+        case "skill":
+          skill = int(option.IntValue())
+        case "bonus":
+          modifier += int(option.IntValue())
+        case "penalty":
+          modifier -= int(option.IntValue())
+        }
+      }
+
+      options := GuildEvalOptions(i.Interaction.GuildID)
+      result, err := RollCoC(skill, modifier, options)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** %s", err))
+        return
+      }
+
+      message := formatCoc(result, modifier)
+      if options.Roller == secureRoller {
+        expression := fmt.Sprintf("coc skill %d, %+d bonus dice", skill, modifier)
+        message = appendReceipt(message, i, expression, []RollRow{{Result: result.Result, Rolls: result.Rolls}})
+      }
+      sendDiscordMessage(s, i, message)
+    },
+    "fitd": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      dice := 0
+      position, effect := "risky", "standard"
+      for _, option := range i.ApplicationCommandData().Options {
+        switch option.Name {
+        case "dice":
+          dice = int(option.IntValue())
+        case "position":
+          position = option.StringValue()
+        case "effect":
+          effect = option.StringValue()
+        }
+      }
+      if !slices.Contains(fitdPositions, position) || !slices.Contains(fitdEffects, effect) {
+        sendDiscordMessage(s, i, fmt.Sprintf("Unknown position '%s' or effect '%s'.", position, effect))
+        return
+      }
+
+      options := GuildEvalOptions(i.Interaction.GuildID)
+      result, err := RollFitd(dice, options)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** %s", err))
+        return
+      }
 
-🎲 Basic Usage  🎲
-**/roll** <expression>
-- Example usage: `+"`"+`/roll 4d10 + 5`+"`"+`
-- You can give it any arithmetic expression with both numbers and dice notation.
-- Dice notation must be in the form XdY, where X and Y are integers.
-- For advantage and disadvantage, you can write ! or ? after your dice notation to get the highest and lowest roll respectively. For example, 4d10! will get the highest of the four rolls, while 4d10? will get the lowest.
-- You can roll up to d200 and up to 20 rolls at once.
+      message := formatFitd(result, position, effect)
+      if options.Roller == secureRoller {
+        expression := fmt.Sprintf("fitd %d dice, %s, %s", dice, position, effect)
+        message = appendReceipt(message, i, expression, []RollRow{{Result: result.Result, Rolls: result.Rolls}})
+      }
+      sendDiscordMessage(s, i, message)
+    },
+    "genesys": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      pool := i.ApplicationCommandData().Options[0].StringValue()
 
-🎲 Macros  🎲
-A macro is an expression you can re-use again and again. Macros can have inputs, which must be written as uppercase letters starting from A. If the macro only has one input, it must be named A; two, must be named A and B, and so on.
+      options := GuildEvalOptions(i.Interaction.GuildID)
+      result, err := genesysSystem.Roll(pool, options)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("**Uh-oh!** %s", err))
+        return
+      }
 
-For example, you can have a macro: `+"`"+`4 * (A + B)`+"`"+`
-You will be able to roll this macro substituting anything you'd like for the variables A and B.
+      message := formatSymbolRoll(genesysSystem, pool, result)
+      if options.Roller == secureRoller {
+        row := RollRow{Result: result.Net[genesysSystem.Success], Rolls: result.Rolls}
+        message = appendReceipt(message, i, "genesys " + pool, []RollRow{row})
+      }
+      sendDiscordMessage(s, i, message)
+    },
+    "odds": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      options := i.ApplicationCommandData().Options
+      expression := options[0].StringValue()
+      var target *int
+      if len(options) == 2 {
+        n := int(options[1].IntValue())
+        target = &n
+      }
 
-**/make-macro** <name> <expression>
-- This is used to create a macro. For example: `+"`"+`/make-macro my-macro 4 * (A + B)`+"`"+`
-- Macros can be named anything, with a maximum of 128 characters.
+      d, err := AnalyzeExpression(expression, GuildEvalOptions(i.Interaction.GuildID))
+      if err != nil {
+        sendDiscordMessage(s, i, formatParseError(expression, err))
+        return
+      }
+      sendDiscordMessage(s, i, formatOdds(expression, d, target))
+    },
+    "simulate": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      var expression string
+      var target *int
+      evalOptions := GuildEvalOptions(i.Interaction.GuildID)
+      options := SimulationOptions{Rounding: evalOptions.Rounding, CustomDice: evalOptions.CustomDice}
+      for _, option := range i.ApplicationCommandData().Options {
+        switch option.Name {
+        case "expression":
+          expression = option.StringValue()
+        case "iterations":
+          options.Iterations = int(option.IntValue())
+        case "target":
+          n := int(option.IntValue())
+          target = &n
+        }
+      }
 
-**/roll-macro** <name> <inputs separated by spaces>
-- This is how you roll a macro once it's created. Specify the name of the macro, following by what you want the A, B, C, etc to be separated by spaces. (They can be either numbers or dice notation.)
-- For example: `+"`"+`/roll-macro my-macro 10 4d6`+"`"+`
+      result, err := Simulate(expression, options)
+      if err != nil {
+        sendDiscordMessage(s, i, formatParseError(expression, err))
+        return
+      }
+      sendDiscordMessage(s, i, formatSimulation(expression, result, target))
+    },
+    "compare-rolls": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      evalOptions := GuildEvalOptions(i.Interaction.GuildID)
+      series := []chartSeries{}
+      asImage := false
+      for _, option := range i.ApplicationCommandData().Options {
+        if option.Name == "png" {
+          asImage = option.BoolValue()
+          continue
+        }
 
-There are several other commands to help you view, edit, and delete macros: 
-**/list-macros** | Lists all macros available.
-**/view-macro** <name> | Displays the macro with the given name.
-**/delete-macro** <name> | Deletes the macro with the given name.
-**/edit-macro** <name> <expression> | Updates the existing macro.
+        expression := option.StringValue()
+        d, err := AnalyzeExpression(expression, evalOptions)
+        if err != nil {
+          sendDiscordMessage(s, i, formatParseError(expression, err))
+          return
+        }
+        series = append(series, chartSeries{Expression: expression, Distribution: d})
+      }
 
-Macros are tied to the server and macros created by this server can only be used in this server. 
+      message := formatComparison(series)
+      if !asImage {
+        chart, err := renderBarChart(series)
+        if err != nil {
+          sendDiscordMessage(s, i, fmt.Sprintf("Unable to draw chart: %s", err))
+          return
+        }
+        sendDiscordMessage(s, i, message + chart)
+        return
+      }
 
-Please enjoy using DiceMancer, and feel free to contact the developer <@284867832376721409> if you have further questions or comments.
-`
-      sendDiscordMessage(s, i, helpMessage)
+      image, err := renderChartPNG(series)
+      if err != nil {
+        sendDiscordMessage(s, i, fmt.Sprintf("Unable to draw chart: %s", err))
+        return
+      }
+      sendDiscordFile(s, i, message, &discordgo.File{
+        Name: "odds.png",
+        ContentType: "image/png",
+        Reader: bytes.NewReader(image),
+      })
+    },
+    "help-me-roll": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+      topic := ""
+      if len(i.ApplicationCommandData().Options) == 1 {
+        topic = i.ApplicationCommandData().Options[0].StringValue()
+      }
+      sendDiscordMessage(s, i, helpMessage(topic))
     },
   }
 
@@ -370,7 +1719,7 @@ Please enjoy using DiceMancer, and feel free to contact the developer <@28486783
     }
     
     if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-      h(s, i)
+      runCommandHandler(h, s, i)
     }
   })
 
@@ -0,0 +1,77 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "unicode/utf8"
+)
+
+/* Test that the largest repeated roll still fits in a single Discord message */
+func TestRepeatedRollMessageLength(t *testing.T) {
+  faces := []int{}
+  for i := 0; i < maxRepeats * 20; i++ {
+    faces = append(faces, 200)
+  }
+  expression := "20x 20d200"
+  rows, err := ParseRepeatedExpression(expression, EvalOptions{Roller: &scriptedRoller{faces: faces}})
+  if err != nil {
+    t.Fatalf("Parsing %s failed with error: %s", expression, err)
+  }
+
+  message := formatRepeatedRollResult(expression, rows)
+  if length := utf8.RuneCountInString(message); length > maxMessageLength {
+    t.Fatalf("Rolling %s gave a message of %d characters, over Discord's limit of %d", expression, length, maxMessageLength)
+  }
+}
+
+/* Test shortening text to fit, keeping whole lines and noting what was left out */
+func TestTruncateLines(t *testing.T) {
+  text := "> one\n> two two two two two two\n> three three three three three\n"
+  if truncated := truncateLines(text, 100); truncated != text {
+    t.Fatalf("Text that fits was changed to %q", truncated)
+  }
+
+  expected := "> one\n> *…and 2 more lines not shown*\n"
+  if truncated := truncateLines(text, 45); truncated != expected {
+    t.Fatalf("Text was shortened to %q instead of %q", truncated, expected)
+  }
+}
+
+/* Test that the largest set of rolls made at once still fits in a single Discord message */
+func TestSectionsMessageLength(t *testing.T) {
+  input := "Attack: 20x 20d200"
+  for i := 1; i < maxRollSections; i++ {
+    input += "; Attack: 20x 20d200"
+  }
+  sections, _, err := rollSections(input, EvalOptions{}, "")
+  if err != nil {
+    t.Fatalf("Rolling %s failed with error: %s", input, err)
+  }
+
+  message := formatSections(input, sections)
+  if length := utf8.RuneCountInString(message); length > maxMessageLength {
+    t.Fatalf("Rolling %d sections gave a message of %d characters, over Discord's limit of %d", maxRollSections, length, maxMessageLength)
+  }
+
+  if _, _, err := rollSections(input + "; d6", EvalOptions{}, ""); err == nil {
+    t.Fatalf("Rolling more than %d sections should fail", maxRollSections)
+  }
+}
+
+/* Test that the Fate ladder is kept when a long roll has to be shortened */
+func TestFooterKeptWhenShortened(t *testing.T) {
+  input := "20x 20d200"
+  sections, _, err := rollSections(input, EvalOptions{}, "")
+  if err != nil {
+    t.Fatalf("Rolling %s failed with error: %s", input, err)
+  }
+
+  footer := formatFateLadder(sections)
+  message := appendFooter(formatRolls(input, sections), footer)
+  if length := utf8.RuneCountInString(message); length > maxMessageLength {
+    t.Fatalf("Rolling %s gave a message of %d characters, over Discord's limit of %d", input, length, maxMessageLength)
+  }
+  if !strings.HasSuffix(message, footer) {
+    t.Fatalf("The Fate ladder was cut off the end of %q", message)
+  }
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "image"
+  "image/color"
+  "image/draw"
+  "image/png"
+  "strings"
+)
+
+/* One expression's distribution, drawn as part of a chart.
+ */
+type chartSeries struct {
+  Expression string
+  Distribution *Distribution
+}
+
+// The characters and colours used for each series, in order
+var chartSymbols = []string{"█", "▓", "▒", "░"}
+var chartLegend = []string{"🟥", "🟦", "🟩", "🟧"}
+var chartColors = []color.NRGBA{
+  {R: 0xe0, G: 0x30, B: 0x30, A: 0x99},
+  {R: 0x30, G: 0x60, B: 0xe0, A: 0x99},
+  {R: 0x30, G: 0xb0, B: 0x40, A: 0x99},
+  {R: 0xf0, G: 0x90, B: 0x20, A: 0x99},
+}
+
+// The most lines a text chart may take up, so it fits in one message
+const maxChartLines = 32
+
+// The widest a bar in a text chart may be, in characters
+const chartBarWidth = 24
+
+/* Finds the lowest and highest results across every series. Fails if
+ * they're further apart than a single distribution may be, since every
+ * result in between is drawn.
+ */
+func chartRange(series []chartSeries) (int, int, error) {
+  low, high := series[0].Distribution.Min, series[0].Distribution.Max()
+  for _, s := range series[1:] {
+    low = min(low, s.Distribution.Min)
+    high = max(high, s.Distribution.Max())
+  }
+  // A negative width means the subtraction overflowed
+  if width := high - low; width < 0 || width >= maxDistributionRange {
+    return 0, 0, errors.New(fmt.Sprintf("The results are spread too far apart to chart (at most %d apart)", maxDistributionRange - 1))
+  }
+  return low, high, nil
+}
+
+/* Draws the distributions as a Unicode bar chart, with a line for each
+ * series at each result. When there are too many results to fit, they
+ * are grouped into buckets covering several results each.
+ */
+func renderBarChart(series []chartSeries) (string, error) {
+  low, high, err := chartRange(series)
+  if err != nil {
+    return "", err
+  }
+  buckets := max(maxChartLines / len(series), 1)
+  bucketSize := (high - low + buckets) / buckets
+
+  // Chance of each series landing in each bucket
+  chances := [][]float64{}
+  largest := 0.0
+  for _, s := range series {
+    row := make([]float64, (high - low) / bucketSize + 1)
+    for value := s.Distribution.Min; value <= s.Distribution.Max(); value++ {
+      row[(value - low) / bucketSize] += s.Distribution.Probability(value)
+    }
+    for _, p := range row {
+      largest = max(largest, p)
+    }
+    chances = append(chances, row)
+  }
+
+  labels := []string{}
+  labelWidth := 0
+  for b := range chances[0] {
+    start := low + b * bucketSize
+    label := fmt.Sprintf("%d", start)
+    if bucketSize > 1 {
+      label = fmt.Sprintf("%d-%d", start, min(start + bucketSize - 1, high))
+    }
+    labels = append(labels, label)
+    labelWidth = max(labelWidth, len(label))
+  }
+
+  chart := "```\n"
+  for b, label := range labels {
+    for i := range series {
+      if i > 0 {
+        label = ""
+      }
+      width := 0
+      if largest > 0 {
+        width = int(chances[i][b] / largest * chartBarWidth + 0.5)
+      }
+      bar := strings.Repeat(chartSymbols[i], width) + strings.Repeat(" ", chartBarWidth - width)
+      chart += fmt.Sprintf("%*s │%s %5.1f%%\n", labelWidth, label, bar, chances[i][b] * 100)
+    }
+  }
+  chart += "```"
+  return chart, nil
+}
+
+// Size and margins of the PNG chart, in pixels
+const chartWidth = 640
+const chartHeight = 360
+const chartMargin = 40
+
+/* Draws the distributions as a PNG, with each series as a set of
+ * semi-transparent bars drawn over each other.
+ */
+func renderChartPNG(series []chartSeries) ([]byte, error) {
+  img := image.NewNRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+  draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+  low, high, err := chartRange(series)
+  if err != nil {
+    return nil, err
+  }
+  largest := 0.0
+  for _, s := range series {
+    for _, p := range s.Distribution.Probabilities {
+      largest = max(largest, p)
+    }
+  }
+
+  plotWidth := chartWidth - chartMargin * 2
+  plotHeight := chartHeight - chartMargin * 2
+  slotWidth := float64(plotWidth) / float64(high - low + 1)
+  axisColor := color.NRGBA{A: 0xff}
+
+  for i, s := range series {
+    for value := s.Distribution.Min; value <= s.Distribution.Max(); value++ {
+      height := int(s.Distribution.Probability(value) / largest * float64(plotHeight))
+      left := chartMargin + int(float64(value - low) * slotWidth)
+      right := chartMargin + int(float64(value - low + 1) * slotWidth)
+      if right - left > 2 {
+        right--
+      }
+      bar := image.Rect(left, chartHeight - chartMargin - height, max(right, left + 1), chartHeight - chartMargin)
+      draw.Draw(img, bar, &image.Uniform{chartColors[i]}, image.Point{}, draw.Over)
+    }
+  }
+
+  // Axes
+  draw.Draw(img, image.Rect(chartMargin, chartMargin, chartMargin + 1, chartHeight - chartMargin + 1), &image.Uniform{axisColor}, image.Point{}, draw.Src)
+  draw.Draw(img, image.Rect(chartMargin, chartHeight - chartMargin, chartWidth - chartMargin, chartHeight - chartMargin + 1), &image.Uniform{axisColor}, image.Point{}, draw.Src)
+
+  // Label about ten of the results along the bottom
+  step := max((high - low + 1) / 10, 1)
+  for value := low; value <= high; value += step {
+    center := chartMargin + int((float64(value - low) + 0.5) * slotWidth)
+    draw.Draw(img, image.Rect(center, chartHeight - chartMargin, center + 1, chartHeight - chartMargin + 4), &image.Uniform{axisColor}, image.Point{}, draw.Src)
+    label := fmt.Sprintf("%d", value)
+    drawText(img, label, center - textWidth(label) / 2, chartHeight - chartMargin + 8, axisColor)
+  }
+
+  // Label the top of the chance axis with the largest chance
+  top := fmt.Sprintf("%.0f%%", largest * 100)
+  drawText(img, top, chartMargin - textWidth(top) - 4, chartMargin, axisColor)
+  drawText(img, "0%", chartMargin - textWidth("0%") - 4, chartHeight - chartMargin - 10, axisColor)
+
+  var buffer bytes.Buffer
+  if err := png.Encode(&buffer, img); err != nil {
+    return nil, err
+  }
+  return buffer.Bytes(), nil
+}
+
+// A tiny 3x5 pixel font, just big enough for chart labels.
+// Each row of a glyph is 3 bits, with the leftmost pixel as the highest bit.
+var chartFont = map[rune][5]uint8{
+  '0': {7, 5, 5, 5, 7},
+  '1': {2, 6, 2, 2, 7},
+  '2': {7, 1, 7, 4, 7},
+  '3': {7, 1, 7, 1, 7},
+  '4': {5, 5, 7, 1, 1},
+  '5': {7, 4, 7, 1, 7},
+  '6': {7, 4, 7, 5, 7},
+  '7': {7, 1, 1, 1, 1},
+  '8': {7, 5, 7, 5, 7},
+  '9': {7, 5, 7, 1, 7},
+  '-': {0, 0, 7, 0, 0},
+  '%': {5, 1, 2, 4, 5},
+}
+
+// How many pixels each pixel of the font is drawn as
+const chartFontScale = 2
+
+/* The width of the given text in pixels, when drawn with drawText.
+ */
+func textWidth(text string) int {
+  return len(text) * 4 * chartFontScale
+}
+
+/* Draws text with its top left corner at (x, y).
+ */
+func drawText(img draw.Image, text string, x int, y int, c color.Color) {
+  for _, r := range text {
+    glyph := chartFont[r]
+    for row := 0; row < 5; row++ {
+      for column := 0; column < 3; column++ {
+        if glyph[row] & (4 >> column) != 0 {
+          pixel := image.Rect(
+            x + column * chartFontScale,
+            y + row * chartFontScale,
+            x + (column + 1) * chartFontScale,
+            y + (row + 1) * chartFontScale,
+          )
+          draw.Draw(img, pixel, &image.Uniform{c}, image.Point{}, draw.Src)
+        }
+      }
+    }
+    x += 4 * chartFontScale
+  }
+}
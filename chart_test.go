@@ -0,0 +1,85 @@
+package main
+
+import (
+  "bytes"
+  "image/png"
+  "strings"
+  "testing"
+)
+
+func analyzeSeries(t *testing.T, expressions ...string) []chartSeries {
+  series := []chartSeries{}
+  for _, expression := range expressions {
+    d, err := AnalyzeExpression(expression, EvalOptions{})
+    if err != nil {
+      t.Fatalf("Analysing %s failed with error: %s", expression, err)
+    }
+    series = append(series, chartSeries{Expression: expression, Distribution: d})
+  }
+  return series
+}
+
+/* Test that the text chart has a line per series per result, scaled to the most likely result */
+func TestRenderBarChart(t *testing.T) {
+  chart, err := renderBarChart(analyzeSeries(t, "d4", "d4 + 2"))
+  if err != nil {
+    t.Fatalf("Drawing chart failed with error: %s", err)
+  }
+  lines := strings.Split(strings.Trim(chart, "`\n"), "\n")
+
+  // Results 1 to 6, with a line for each expression
+  if len(lines) != 12 {
+    t.Fatalf("Bar chart had %d lines instead of 12:\n%s", len(lines), chart)
+  }
+  if !strings.HasPrefix(lines[0], "1 │█") || !strings.Contains(lines[0], "25.0%") {
+    t.Fatalf("Bar chart line for d4 rolling 1 was %q", lines[0])
+  }
+  if strings.Contains(lines[1], "▓") || !strings.Contains(lines[1], "0.0%") {
+    t.Fatalf("Bar chart line for d4 + 2 rolling 1 was %q", lines[1])
+  }
+  if strings.Count(lines[11], "▓") != chartBarWidth {
+    t.Fatalf("Bar chart line for d4 + 2 rolling 6 was %q", lines[11])
+  }
+}
+
+/* Test that wide ranges are grouped so the chart still fits in a message */
+func TestRenderBarChartBuckets(t *testing.T) {
+  chart, err := renderBarChart(analyzeSeries(t, "10d20", "3d20 * 4", "d200", "100"))
+  if err != nil {
+    t.Fatalf("Drawing chart failed with error: %s", err)
+  }
+  lines := strings.Split(strings.Trim(chart, "`\n"), "\n")
+  if len(lines) > maxChartLines {
+    t.Fatalf("Bar chart had %d lines, more than the limit of %d", len(lines), maxChartLines)
+  }
+  if len([]rune(chart)) > 2000 {
+    t.Fatalf("Bar chart was %d characters, too long for one message", len([]rune(chart)))
+  }
+}
+
+/* Test that the PNG chart is a valid image */
+func TestRenderChartPNG(t *testing.T) {
+  data, err := renderChartPNG(analyzeSeries(t, "2d6", "d12", "-5"))
+  if err != nil {
+    t.Fatalf("Drawing chart failed with error: %s", err)
+  }
+
+  img, err := png.Decode(bytes.NewReader(data))
+  if err != nil {
+    t.Fatalf("Chart was not a valid PNG: %s", err)
+  }
+  if img.Bounds().Dx() != chartWidth || img.Bounds().Dy() != chartHeight {
+    t.Fatalf("Chart was %v instead of %dx%d", img.Bounds(), chartWidth, chartHeight)
+  }
+}
+
+/* Test that series too far apart to draw every result in between are rejected */
+func TestChartRangeTooWide(t *testing.T) {
+  series := analyzeSeries(t, "d6", "d6 + 1000000")
+  if _, err := renderBarChart(series); err == nil {
+    t.Fatalf("Drawing a text chart of results a million apart should fail")
+  }
+  if _, err := renderChartPNG(series); err == nil {
+    t.Fatalf("Drawing a PNG chart of results a million apart should fail")
+  }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+)
+
+// The most bonus or penalty dice a Call of Cthulhu roll may have
+const maxCocDice = 2
+
+// The levels of success a Call of Cthulhu roll can have, from best to worst
+const (
+  CocCritical = "critical"
+  CocExtreme = "extreme"
+  CocHard = "hard"
+  CocRegular = "regular"
+  CocFailure = "failure"
+  CocFumble = "fumble"
+)
+
+/* The result of a Call of Cthulhu (7th edition) percentile roll.
+ * Tens holds every tens die rolled (0, 10, ... 90), and Kept is the
+ * index of the one that was used. Rolls are the dice behind them.
+ */
+type CocResult struct {
+  Skill int
+  Tens []int
+  Kept int
+  Units int
+  Result int
+  Level string
+  Rolls []DiceRoll
+}
+
+/* Makes a Call of Cthulhu roll against a skill value. A positive
+ * modifier is that many bonus dice, and a negative one that many
+ * penalty dice. Each bonus or penalty die rolls another tens die,
+ * keeping the best or worst of them.
+ */
+func RollCoC(skill int, modifier int, options EvalOptions) (*CocResult, error) {
+  if skill < 1 {
+    return nil, errors.New("The skill value must be at least 1")
+  }
+  if modifier > maxCocDice || modifier < -maxCocDice {
+    return nil, errors.New(fmt.Sprintf("A roll can have at most %d bonus or penalty dice", maxCocDice))
+  }
+
+  // The tens and units are rolled as d10s, with a 10 counting as 0
+  _, tensRolls, err := ParseExpressionWithOptions(fmt.Sprintf("%dd10", 1 + max(modifier, -modifier)), options)
+  if err != nil {
+    return nil, err
+  }
+  units, unitsRolls, err := ParseExpressionWithOptions("d10", options)
+  if err != nil {
+    return nil, err
+  }
+
+  result := &CocResult{Skill: skill, Units: units % 10, Rolls: append(tensRolls, unitsRolls...)}
+  for i, face := range tensRolls[0].Results {
+    result.Tens = append(result.Tens, face % 10 * 10)
+    total := cocTotal(result.Tens[i], result.Units)
+    better := result.Result == 0 || (modifier > 0 && total < result.Result) || (modifier < 0 && total > result.Result)
+    if better {
+      result.Kept = i
+      result.Result = total
+    }
+  }
+  result.Level = cocLevel(result.Result, skill)
+  return result, nil
+}
+
+/* Adds up a tens die and a units die, where 00 and 0 make 100.
+ */
+func cocTotal(tens int, units int) int {
+  if tens == 0 && units == 0 {
+    return 100
+  }
+  return tens + units
+}
+
+/* Grades a roll against a skill value. A 1 is a critical success, and
+ * a 100 is always a fumble, as is 96 or more when the skill is under 50.
+ */
+func cocLevel(roll int, skill int) string {
+  switch {
+  case roll == 1:
+    return CocCritical
+  case roll == 100 || (skill < 50 && roll >= 96):
+    return CocFumble
+  case roll <= skill / 5:
+    return CocExtreme
+  case roll <= skill / 2:
+    return CocHard
+  case roll <= skill:
+    return CocRegular
+  }
+  return CocFailure
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+  "slices"
+  "testing"
+)
+
+/* Test Call of Cthulhu rolls, with bonus and penalty dice, against known rolls */
+func TestRollCoC(t *testing.T) {
+  cases := []struct {
+    skill int
+    modifier int
+    faces []int
+    tens []int
+    units int
+    result int
+    level string
+  }{
+    {60, 0, []int{4, 5}, []int{40}, 5, 45, CocRegular},
+    {60, 0, []int{3, 10}, []int{30}, 0, 30, CocHard},
+    {60, 0, []int{1, 2}, []int{10}, 2, 12, CocExtreme},
+    {60, 0, []int{10, 1}, []int{0}, 1, 1, CocCritical},
+    {60, 0, []int{10, 10}, []int{0}, 0, 100, CocFumble},
+    {40, 0, []int{9, 7}, []int{90}, 7, 97, CocFumble},
+    {60, 0, []int{9, 7}, []int{90}, 7, 97, CocFailure},
+    {60, 1, []int{7, 3, 4}, []int{70, 30}, 4, 34, CocRegular},
+    {60, -2, []int{2, 8, 5, 4}, []int{20, 80, 50}, 4, 84, CocFailure},
+    // 00 with a units die of 0 is 100, which is worst with a penalty die
+    {60, -1, []int{10, 5, 10}, []int{0, 50}, 0, 100, CocFumble},
+    {60, 1, []int{10, 5, 10}, []int{0, 50}, 0, 50, CocRegular},
+  }
+
+  for _, c := range cases {
+    result, err := RollCoC(c.skill, c.modifier, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Rolling against %d failed with error: %s", c.skill, err)
+    }
+    if !slices.Equal(result.Tens, c.tens) || result.Units != c.units {
+      t.Fatalf("Rolling %v: tens were %v and units %d instead of %v and %d", c.faces, result.Tens, result.Units, c.tens, c.units)
+    }
+    if result.Result != c.result || result.Level != c.level {
+      t.Fatalf("Rolling %v against %d: got %d (%s) instead of %d (%s)", c.faces, c.skill, result.Result, result.Level, c.result, c.level)
+    }
+  }
+
+  for _, modifier := range []int{3, -3} {
+    if _, err := RollCoC(50, modifier, EvalOptions{}); err == nil {
+      t.Fatalf("Rolling with a modifier of %d should fail", modifier)
+    }
+  }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "regexp"
+  "strings"
+
+  "gorm.io/gorm"
+)
+
+/* A custom die defined by a server, stored as its faces,
+ * ex. "0, 0, 1, 1, 2, 3" or "head, torso, left arm, right arm".
+ * It's rolled in expressions as d{name}.
+ */
+type NamedDie struct {
+  gorm.Model
+  Guild string
+  Name string
+  Faces string
+}
+
+// Matches the name of a custom die, which is written between braces in expressions
+var dieName = regexp.MustCompile(`^[A-Za-z][\w-]{0,31}$`)
+
+/* Checks if a custom die name is valid.
+ */
+func ValidateDieName(name string) error {
+  if !dieName.MatchString(name) {
+    return errors.New("Die names must start with a letter, and have at most 32 letters, numbers, - or _.")
+  }
+  return nil
+}
+
+/* Loads every custom die the given server has made, by lowercase name.
+ * Dice whose faces no longer parse are left out.
+ */
+func LoadCustomDice(guild string) map[string]CustomDie {
+  dice := map[string]CustomDie{}
+  named, err := ListNamedDice(guild)
+  if err != nil {
+    return dice
+  }
+  for _, die := range named {
+    faces, err := ParseDieFaces(die.Faces)
+    if err != nil {
+      continue
+    }
+    name := strings.ToLower(die.Name)
+    dice[name] = CustomDie{Name: name, Faces: faces}
+  }
+  return dice
+}
+
+func FindNamedDie(guild string, name string) (*NamedDie, error) {
+  var die NamedDie
+
+  result := db.Where("Guild = ? AND Name = ?", guild, strings.ToLower(name)).First(&die)
+  if result.Error != nil {
+    if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+      return nil, errors.New(fmt.Sprintf("No die named '%s' was found.", name))
+    }
+    return nil, errors.New("Database error")
+  }
+
+  return &die, nil
+}
+
+func MakeNamedDie(die *NamedDie) {
+  die.Name = strings.ToLower(die.Name)
+  db.Create(die)
+}
+
+func DeleteNamedDie(die *NamedDie) {
+  db.Delete(die)
+}
+
+func ListNamedDice(guild string) ([]NamedDie, error) {
+  var dice []NamedDie
+  result := db.Where("Guild = ?", guild).Order("Name").Find(&dice)
+  if result.Error != nil {
+    return nil, errors.New("Database error (possibly no rows found)")
+  }
+  return dice, nil
+}
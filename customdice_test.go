@@ -0,0 +1,19 @@
+package main
+
+import (
+  "testing"
+)
+
+/* Test which names custom dice may have */
+func TestValidateDieName(t *testing.T) {
+  for _, name := range []string{"avalanche", "Hit-Location", "d6_alt", "x"} {
+    if err := ValidateDieName(name); err != nil {
+      t.Fatalf("'%s' should be a valid die name, got error: %s", name, err)
+    }
+  }
+  for _, name := range []string{"", "6sided", "hit location", "ava}lanche", "abcdefghijklmnopqrstuvwxyzabcdefg"} {
+    if err := ValidateDieName(name); err == nil {
+      t.Fatalf("'%s' should not be a valid die name", name)
+    }
+  }
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+  "errors"
+  "slices"
+)
+
+/* Flags describing what happened to a single die in a DiceRoll.
+ * A die with no flags set was rolled once and counts towards the total.
+ */
+type DieFlag int
+
+const (
+  // The die was discarded by a keep/drop modifier (kh, kl, dh, dl, ! or ?)
+  DieDropped DieFlag = 1 << iota
+  // The die met the explode threshold, so another die was rolled after it
+  DieExploded
+  // The die was added onto the die before it by a compounding explosion
+  DieCompounded
+  // The die was rerolled, and the die after it replaces it
+  DieRerolled
+  // The die met the success target, adding a success
+  DieSuccess
+  // The die met the failure target, taking away a success
+  DieFailure
+)
+
+// The most extra dice a single piece of dice notation may explode into
+const maxExplosions = 100
+
+// The most times the dice in a single piece of dice notation may be rerolled
+const maxRerolls = 100
+
+/* Struct representing the result(s) of a dice roll.
+ * Flags runs parallel to Results and records what happened to each die.
+ * Label is the label written in square brackets on the dice, or on
+ * a part of the expression containing them, ex. fire in d6[fire].
+ */
+type DiceRoll struct {
+  Expression string
+  Sides int
+  Results []int
+  Flags []DieFlag
+  Label string
+  // Fate dice show -1, 0 or +1 instead of 1 to Sides
+  Fate bool
+  // For custom dice, the face that came up for each result
+  Faces []DieFace
+}
+
+/* A comparison against a target number, such as >=9 in d10e>=9.
+ */
+type comparison struct {
+  op string
+  target int
+}
+
+/* Checks if the given value satisfies the comparison.
+ */
+func (c comparison) matches(value int) bool {
+  switch c.op {
+  case "=", "==":
+    return value == c.target
+  case "!=":
+    return value != c.target
+  case ">":
+    return value > c.target
+  case ">=":
+    return value >= c.target
+  case "<":
+    return value < c.target
+  case "<=":
+    return value <= c.target
+  }
+  return false
+}
+
+/* Checks if the comparison matches every face of a die with the given sides.
+ */
+func (c comparison) matchesEveryFace(sides int) bool {
+  for face := 1; face <= sides; face++ {
+    if !c.matches(face) {
+      return false
+    }
+  }
+  return true
+}
+
+/* The parsed form of a piece of dice notation such as 4d6kh3,
+ * built up by applying each of its modifiers in turn.
+ * The count on a keep/drop modifier defaults to 1 (kh is kh1), and
+ * exploding dice explode on their highest face unless given a threshold.
+ * Fate dice (dF) are rolled as d3s and then shifted to show -1, 0 or +1.
+ */
+type diceSpec struct {
+  count int
+  sides int
+  fate bool
+  // The lowercase name of a custom die, whose faces are looked up when it's rolled
+  custom string
+  keepMode string
+  keepCount int
+  explodeMode string
+  explodeOn comparison
+  rerollMode string
+  rerollOn comparison
+  successOn *comparison
+  failureOn *comparison
+}
+
+/* A modifier written after dice notation, such as kh3 or !!.
+ * Each kind of modifier is its own node type, which records itself
+ * onto the diceSpec that gets rolled.
+ */
+type DiceModifier interface {
+  apply(spec *diceSpec) error
+}
+
+/* Keeps or drops the highest or lowest dice: kh, kl, dh, dl, ! and ?
+ * Mode is one of "kh", "kl", "dh" or "dl".
+ */
+type KeepModifier struct {
+  Mode string
+  Count int
+}
+
+func (m KeepModifier) apply(spec *diceSpec) error {
+  if spec.keepMode != "" {
+    return errors.New("dice can only have one keep/drop modifier")
+  }
+  spec.keepMode, spec.keepCount = m.Mode, m.Count
+  return nil
+}
+
+/* Rolls an extra die whenever a die meets the threshold: !!, !!c, !!p, e, ec, ep
+ * Mode is one of "explode", "compound" or "penetrate".
+ */
+type ExplodeModifier struct {
+  Mode string
+  On comparison
+}
+
+func (m ExplodeModifier) apply(spec *diceSpec) error {
+  if spec.explodeMode != "" {
+    return errors.New("dice can only explode once")
+  }
+  if m.On.matchesEveryFace(spec.sides) {
+    return errors.New("dice would explode on every roll")
+  }
+  spec.explodeMode, spec.explodeOn = m.Mode, m.On
+  return nil
+}
+
+/* Rerolls dice that match: r rerolls until the die no longer matches,
+ * while ro only rerolls once.
+ */
+type RerollModifier struct {
+  Once bool
+  On comparison
+}
+
+func (m RerollModifier) apply(spec *diceSpec) error {
+  if spec.rerollMode != "" {
+    return errors.New("dice can only have one reroll modifier")
+  }
+  if !m.Once && m.On.matchesEveryFace(spec.sides) {
+    return errors.New("dice would be rerolled forever")
+  }
+  spec.rerollMode, spec.rerollOn = "reroll", m.On
+  if m.Once {
+    spec.rerollMode = "once"
+  }
+  return nil
+}
+
+/* Counts the dice that meet a target instead of adding them up, ex. >=8
+ */
+type SuccessModifier struct {
+  On comparison
+}
+
+func (m SuccessModifier) apply(spec *diceSpec) error {
+  if spec.successOn != nil {
+    return errors.New("dice can only have one success target")
+  }
+  spec.successOn = &m.On
+  return nil
+}
+
+/* Takes away a success for every die that meets a target, ex. f1
+ */
+type FailureModifier struct {
+  On comparison
+}
+
+func (m FailureModifier) apply(spec *diceSpec) error {
+  if spec.failureOn != nil {
+    return errors.New("dice can only have one failure target")
+  }
+  spec.failureOn = &m.On
+  return nil
+}
+
+/* Checks that the dice can be rolled once every modifier is applied.
+ */
+func (spec diceSpec) validate() error {
+  if spec.custom == "" && (spec.sides < 1 || spec.sides > 200) {
+    return errors.New("dice must have between 1 and 200 sides")
+  }
+  if spec.count < 1 || spec.count > 20 {
+    return errors.New("you can only roll between 1 and 20 dice at once")
+  }
+  if spec.failureOn != nil && spec.successOn == nil {
+    return errors.New("failures can only be counted along with a success target")
+  }
+  if spec.fate && (spec.explodeMode != "" || spec.rerollMode != "" || spec.successOn != nil) {
+    return errors.New("Fate dice can only be kept or dropped")
+  }
+  if spec.custom != "" && (spec.explodeMode != "" || spec.rerollMode != "" || spec.successOn != nil) {
+    return errors.New("custom dice can only be kept or dropped")
+  }
+  return nil
+}
+
+/* Groups compounded dice together with the die they were added onto,
+ * so that each group can be treated as a single die.
+ * Returns the indices of the dice in each group, and each group's value.
+ * Dice that were rerolled are left out since they no longer count.
+ */
+func groupDice(rolls []int, flags []DieFlag) ([][]int, []int) {
+  groups := [][]int{}
+  values := []int{}
+  for i, rollValue := range rolls {
+    if flags[i] & DieRerolled != 0 {
+      continue
+    }
+    if flags[i] & DieCompounded != 0 && len(groups) > 0 {
+      groups[len(groups)-1] = append(groups[len(groups)-1], i)
+      values[len(values)-1] += rollValue
+    } else {
+      groups = append(groups, []int{i})
+      values = append(values, rollValue)
+    }
+  }
+  return groups, values
+}
+
+/* Marks dice as dropped according to a keep/drop modifier.
+ * Dice joined together by compounding are kept or dropped as one.
+ * Ties are broken by position, so the earlier of two equal dice is
+ * treated as the lower one.
+ */
+func applyKeepDrop(rolls []int, flags []DieFlag, mode string, n int) {
+  groups, values := groupDice(rolls, flags)
+
+  // Indices of the groups, ordered from lowest to highest value
+  order := make([]int, len(groups))
+  for i := range order {
+    order[i] = i
+  }
+  slices.SortStableFunc(order, func(a, b int) int {
+    return values[a] - values[b]
+  })
+
+  n = min(max(n, 0), len(order))
+  var dropped []int
+  switch mode {
+  case "kh":
+    dropped = order[:len(order)-n]
+  case "kl":
+    dropped = order[n:]
+  case "dh":
+    dropped = order[len(order)-n:]
+  case "dl":
+    dropped = order[:n]
+  }
+
+  for _, g := range dropped {
+    for _, i := range groups[g] {
+      flags[i] |= DieDropped
+    }
+  }
+}
+
+/* Marks each die that was kept as a success or failure, and
+ * returns the number of successes minus the number of failures.
+ * A die that meets both targets only counts as a success.
+ */
+func countSuccesses(rolls []int, flags []DieFlag, successOn comparison, failureOn *comparison) int {
+  groups, values := groupDice(rolls, flags)
+
+  successes := 0
+  for g, group := range groups {
+    if flags[group[0]] & DieDropped != 0 {
+      continue
+    }
+
+    var flag DieFlag
+    if successOn.matches(values[g]) {
+      flag = DieSuccess
+      successes++
+    } else if failureOn != nil && failureOn.matches(values[g]) {
+      flag = DieFailure
+      successes--
+    }
+
+    for _, i := range group {
+      flags[i] |= flag
+    }
+  }
+  return successes
+}
+
+/* Randomly rolls the dice described by the given spec, using the given Roller.
+ * Returns the total of the dice that were kept, along with every
+ * individual roll and what happened to it.
+ * Extra dice from explosions appear directly after the die that
+ * exploded. Penetrating explosions subtract 1 from each extra die.
+ * Rerolled dice are kept in the results, directly before the die
+ * that replaced them.
+ * When counting successes, the total is the number of successes
+ * rather than the sum of the dice.
+ */
+func rollDice(spec diceSpec, roller Roller) (int, []int, []DieFlag) {
+  rolls := []int{}
+  flags := []DieFlag{}
+  explosions := 0
+  rerolls := 0
+
+  // Rolls a single die, recording any faces that get rerolled along the way
+  rollFace := func(flag DieFlag) int {
+    face := roller.Intn(spec.sides) + 1
+    for spec.rerollMode != "" && spec.rerollOn.matches(face) && rerolls < maxRerolls {
+      rolls = append(rolls, face)
+      flags = append(flags, flag | DieRerolled)
+      rerolls++
+
+      face = roller.Intn(spec.sides) + 1
+      if spec.rerollMode == "once" {
+        break
+      }
+    }
+    return face
+  }
+
+  for i := 0; i < spec.count; i++ {
+    face := rollFace(0)
+    rolls = append(rolls, face)
+    flags = append(flags, 0)
+
+    for spec.explodeMode != "" && spec.explodeOn.matches(face) && explosions < maxExplosions {
+      flags[len(flags)-1] |= DieExploded
+      explosions++
+
+      var flag DieFlag
+      if spec.explodeMode == "compound" {
+        flag = DieCompounded
+      }
+      face = rollFace(flag)
+      rollValue := face
+      if spec.explodeMode == "penetrate" {
+        rollValue = face - 1
+      }
+      rolls = append(rolls, rollValue)
+      flags = append(flags, flag)
+    }
+  }
+
+  if spec.fate {
+    for i := range rolls {
+      rolls[i] -= 2
+    }
+  }
+
+  if spec.keepMode != "" {
+    applyKeepDrop(rolls, flags, spec.keepMode, spec.keepCount)
+  }
+
+  if spec.successOn != nil {
+    return countSuccesses(rolls, flags, *spec.successOn, spec.failureOn), rolls, flags
+  }
+
+  result := 0
+  for i, rollValue := range rolls {
+    if flags[i] & (DieDropped | DieRerolled) == 0 {
+      result += rollValue
+    }
+  }
+
+  return result, rolls, flags
+}
+
+/* Rolls custom dice, which show the value of whichever face comes up.
+ * Returns the total of the dice that were kept, along with the value
+ * and face of every die and whether it was dropped.
+ */
+func rollCustomDice(spec diceSpec, die CustomDie, roller Roller) (int, []int, []DieFace, []DieFlag) {
+  rolls := []int{}
+  faces := []DieFace{}
+  flags := []DieFlag{}
+  for i := 0; i < spec.count; i++ {
+    face := die.Faces[die.roll(roller) - 1]
+    rolls = append(rolls, face.Value)
+    faces = append(faces, face)
+    flags = append(flags, 0)
+  }
+
+  if spec.keepMode != "" {
+    applyKeepDrop(rolls, flags, spec.keepMode, spec.keepCount)
+  }
+
+  result := 0
+  for i, rollValue := range rolls {
+    if flags[i] & DieDropped == 0 {
+      result += rollValue
+    }
+  }
+  return result, rolls, faces, flags
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "slices"
+)
+
+// The most distinct results a distribution may have while it is being worked out
+const maxDistributionSize = 100000
+
+// The widest a distribution's range of results may be, since every
+// result between the lowest and highest gets its own probability
+const maxDistributionRange = 100000
+
+// The most steps worth of work exact analysis of a single piece of dice notation may take
+const maxDiceAnalysisWork = 20000000
+
+/* The exact probability of every possible result of an expression.
+ * Probabilities[i] is the chance of the result being Min + i.
+ */
+type Distribution struct {
+  Min int
+  Probabilities []float64
+}
+
+/* The chance of every result, by result, while a distribution is being built up.
+ */
+type outcomes map[int]float64
+
+/* Works out the exact distribution of results for the given expression,
+ * without rolling any dice. It accepts the same grammar as ParseExpression.
+ * Exploding dice can't be analysed exactly, and rerolls are assumed to
+ * never hit the reroll limit.
+ */
+func AnalyzeExpression(input string, options EvalOptions) (*Distribution, error) {
+  tree, err := parse(input)
+  if err != nil {
+    return nil, err
+  }
+
+  rounding := options.Rounding
+  if rounding == "" {
+    rounding = "floor"
+  }
+
+  results, err := analyze(tree, rounding)
+  if err != nil {
+    return nil, err
+  }
+
+  return newDistribution(results)
+}
+
+/* Converts outcomes into a Distribution.
+ */
+func newDistribution(results outcomes) (*Distribution, error) {
+  values := []int{}
+  for value := range results {
+    values = append(values, value)
+  }
+  slices.Sort(values)
+
+  // A negative width means the subtraction overflowed
+  width := values[len(values)-1] - values[0]
+  if width < 0 || width >= maxDistributionRange {
+    return nil, errors.New(fmt.Sprintf("The results are spread too far apart to analyse exactly (at most %d apart)", maxDistributionRange - 1))
+  }
+
+  d := &Distribution{Min: values[0], Probabilities: make([]float64, width + 1)}
+  for _, value := range values {
+    d.Probabilities[value - d.Min] = results[value]
+  }
+  return d, nil
+}
+
+/* The highest possible result.
+ */
+func (d *Distribution) Max() int {
+  return d.Min + len(d.Probabilities) - 1
+}
+
+/* The chance of the result being exactly n.
+ */
+func (d *Distribution) Probability(n int) float64 {
+  if n < d.Min || n > d.Max() {
+    return 0
+  }
+  return d.Probabilities[n - d.Min]
+}
+
+/* The chance of the result being n or higher.
+ */
+func (d *Distribution) AtLeast(n int) float64 {
+  total := 0.0
+  for value := max(n, d.Min); value <= d.Max(); value++ {
+    total += d.Probabilities[value - d.Min]
+  }
+  return min(total, 1)
+}
+
+/* The average result.
+ */
+func (d *Distribution) Mean() float64 {
+  mean := 0.0
+  for i, p := range d.Probabilities {
+    mean += float64(d.Min + i) * p
+  }
+  return mean
+}
+
+/* The standard deviation of the result.
+ */
+func (d *Distribution) StdDev() float64 {
+  mean := d.Mean()
+  variance := 0.0
+  for i, p := range d.Probabilities {
+    difference := float64(d.Min + i) - mean
+    variance += difference * difference * p
+  }
+  return math.Sqrt(variance)
+}
+
+/* Works out the outcomes of a node in the syntax tree.
+ * Every piece of dice notation is rolled independently, so the outcomes
+ * of two sub-expressions can always be combined as independent.
+ */
+func analyze(node Node, rounding string) (outcomes, error) {
+  switch n := node.(type) {
+  case NumberNode:
+    return outcomes{n.Value: 1}, nil
+  case DiceNode:
+    return analyzeDice(n.spec)
+  case LabelNode:
+    return analyze(n.Operand, rounding)
+  case UnaryNode:
+    operand, err := analyze(n.Operand, rounding)
+    if err != nil {
+      return nil, err
+    }
+    return combineOutcomes([]outcomes{operand}, func(values []int) (int, error) {
+      return applyUnary(n.Op, values[0], n.Pos)
+    })
+  case BinaryNode:
+    left, right, err := analyzePair(n.Left, n.Right, rounding)
+    if err != nil {
+      return nil, err
+    }
+    return combineOutcomes([]outcomes{left, right}, func(values []int) (int, error) {
+      return applyOperator(n.Op, values[0], values[1], rounding, n.Pos)
+    })
+  case ComparisonNode:
+    left, right, err := analyzePair(n.Left, n.Right, rounding)
+    if err != nil {
+      return nil, err
+    }
+    return combineOutcomes([]outcomes{left, right}, func(values []int) (int, error) {
+      if (comparison{op: n.Op, target: values[1]}).matches(values[0]) {
+        return 1, nil
+      }
+      return 0, nil
+    })
+  case ConditionalNode:
+    condition, err := analyze(n.Condition, rounding)
+    if err != nil {
+      return nil, err
+    }
+    chanceTrue := 0.0
+    for value, p := range condition {
+      if value != 0 {
+        chanceTrue += p
+      }
+    }
+
+    results := outcomes{}
+    if chanceTrue > 0 {
+      then, err := analyze(n.Then, rounding)
+      if err != nil {
+        return nil, err
+      }
+      for value, p := range then {
+        results[value] += p * chanceTrue
+      }
+    }
+    if chanceTrue < 1 {
+      otherwise, err := analyze(n.Else, rounding)
+      if err != nil {
+        return nil, err
+      }
+      for value, p := range otherwise {
+        results[value] += p * (1 - chanceTrue)
+      }
+    }
+    return results, nil
+  case FunctionNode:
+    fn := functions[n.Name]
+    if fn.rounding != "" {
+      rounding = fn.rounding
+    }
+    args := []outcomes{}
+    for _, arg := range n.Args {
+      results, err := analyze(arg, rounding)
+      if err != nil {
+        return nil, err
+      }
+      args = append(args, results)
+    }
+    return combineOutcomes(args, func(values []int) (int, error) {
+      return callFunction(fn, values, n.Pos)
+    })
+  }
+
+  return nil, errors.New(fmt.Sprintf("Unable to analyse %T exactly", node))
+}
+
+/* Works out the outcomes of two sub-expressions.
+ */
+func analyzePair(left Node, right Node, rounding string) (outcomes, outcomes, error) {
+  leftResults, err := analyze(left, rounding)
+  if err != nil {
+    return nil, nil, err
+  }
+  rightResults, err := analyze(right, rounding)
+  if err != nil {
+    return nil, nil, err
+  }
+  return leftResults, rightResults, nil
+}
+
+/* Combines the outcomes of independent sub-expressions, by applying f to
+ * every combination of their results. Any error from f means that
+ * combination can happen, such as a possible division by zero, so the
+ * whole expression can't be analysed.
+ */
+func combineOutcomes(args []outcomes, f func(values []int) (int, error)) (outcomes, error) {
+  work := 1
+  for _, arg := range args {
+    work *= len(arg)
+    if work > maxDistributionSize * 10 {
+      return nil, errors.New("The expression has too many possible results to analyse exactly")
+    }
+  }
+
+  results := outcomes{}
+  values := make([]int, len(args))
+  var visit func(i int, p float64) error
+  visit = func(i int, p float64) error {
+    if i == len(args) {
+      value, err := f(values)
+      if err != nil {
+        return err
+      }
+      results[value] += p
+      return nil
+    }
+    for value, q := range args[i] {
+      values[i] = value
+      if err := visit(i + 1, p * q); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+  if err := visit(0, 1); err != nil {
+    return nil, err
+  }
+
+  if len(results) > maxDistributionSize {
+    return nil, errors.New("The expression has too many possible results to analyse exactly")
+  }
+  return results, nil
+}
+
+/* Works out the chance of each face on a single die, after rerolls.
+ * A repeated reroll is treated as picking evenly from the faces that
+ * don't get rerolled.
+ */
+func faceChances(spec diceSpec) []float64 {
+  chances := make([]float64, spec.sides + 1)
+  rerolled := 0
+  for face := 1; face <= spec.sides; face++ {
+    if spec.rerollMode != "" && spec.rerollOn.matches(face) {
+      rerolled++
+    }
+  }
+
+  for face := 1; face <= spec.sides; face++ {
+    isRerolled := spec.rerollMode != "" && spec.rerollOn.matches(face)
+    switch spec.rerollMode {
+    case "reroll":
+      if !isRerolled {
+        chances[face] = 1 / float64(spec.sides - rerolled)
+      }
+    case "once":
+      chances[face] = float64(rerolled) / float64(spec.sides * spec.sides)
+      if !isRerolled {
+        chances[face] += 1 / float64(spec.sides)
+      }
+    default:
+      chances[face] = 1 / float64(spec.sides)
+    }
+  }
+  return chances
+}
+
+/* Works out the outcomes of rolling a single piece of dice notation.
+ */
+func analyzeDice(spec diceSpec) (outcomes, error) {
+  if spec.custom != "" {
+    return nil, errors.New("Custom dice can't be analysed exactly")
+  }
+  if spec.explodeMode != "" {
+    return nil, errors.New("Exploding dice can't be analysed exactly")
+  }
+
+  // Fate dice are worked out as d3s, then shifted down by 2 for every die that counts
+  if spec.fate {
+    spec.fate = false
+    results, err := analyzeDice(spec)
+    if err != nil {
+      return nil, err
+    }
+    counted := spec.count
+    if spec.keepMode != "" {
+      counted = min(max(spec.keepCount, 0), spec.count)
+      if spec.keepMode == "dh" || spec.keepMode == "dl" {
+        counted = spec.count - counted
+      }
+    }
+    shifted := outcomes{}
+    for value, p := range results {
+      shifted[value - 2 * counted] += p
+    }
+    return shifted, nil
+  }
+
+  chances := faceChances(spec)
+
+  // Counting successes: each die adds 1, 0 or -1 independently
+  if spec.successOn != nil {
+    if spec.keepMode != "" {
+      return nil, errors.New("Counting successes on kept dice can't be analysed exactly")
+    }
+    die := outcomes{}
+    for face := 1; face <= spec.sides; face++ {
+      switch {
+      case spec.successOn.matches(face):
+        die[1] += chances[face]
+      case spec.failureOn != nil && spec.failureOn.matches(face):
+        die[-1] += chances[face]
+      default:
+        die[0] += chances[face]
+      }
+    }
+    return sumOfDice(die, spec.count)
+  }
+
+  if spec.keepMode == "" {
+    die := outcomes{}
+    for face := 1; face <= spec.sides; face++ {
+      die[face] = chances[face]
+    }
+    return sumOfDice(die, spec.count)
+  }
+
+  return analyzeKeepDrop(spec, chances)
+}
+
+/* Works out the outcomes of adding up count independent dice.
+ */
+func sumOfDice(die outcomes, count int) (outcomes, error) {
+  results := outcomes{0: 1}
+  for i := 0; i < count; i++ {
+    var err error
+    results, err = combineOutcomes([]outcomes{results, die}, func(values []int) (int, error) {
+      return values[0] + values[1], nil
+    })
+    if err != nil {
+      return nil, err
+    }
+  }
+  return results, nil
+}
+
+/* Works out the outcomes of rolling dice and keeping only some of them.
+ * The faces are visited in the order the dice are kept (highest first
+ * for kh and dl, lowest first for kl and dh), deciding how many dice
+ * show each face. Only the first dice visited count towards the total.
+ */
+func analyzeKeepDrop(spec diceSpec, chances []float64) (outcomes, error) {
+  n := spec.count
+  keep := min(max(spec.keepCount, 0), n)
+  highestFirst := spec.keepMode == "kh" || spec.keepMode == "dl"
+  if spec.keepMode == "dh" || spec.keepMode == "dl" {
+    keep = n - keep
+  }
+
+  if spec.sides * (n + 1) * (n + 1) * (keep * spec.sides + 1) > maxDiceAnalysisWork {
+    return nil, errors.New("Too many dice to analyse exactly")
+  }
+
+  // state[placed][total] is the chance of having decided on placed
+  // dice so far, with the kept ones adding up to total
+  state := make([]outcomes, n + 1)
+  for i := range state {
+    state[i] = outcomes{}
+  }
+  state[0][0] = 1
+
+  for step := 0; step < spec.sides; step++ {
+    face := step + 1
+    if highestFirst {
+      face = spec.sides - step
+    }
+
+    next := make([]outcomes, n + 1)
+    for i := range next {
+      next[i] = outcomes{}
+    }
+    for placed := 0; placed <= n; placed++ {
+      for total, p := range state[placed] {
+        for count := 0; placed + count <= n; count++ {
+          kept := min(max(keep - placed, 0), count)
+          chance := p * binomial(n - placed, count) * math.Pow(chances[face], float64(count))
+          if chance > 0 {
+            next[placed + count][total + kept * face] += chance
+          }
+        }
+      }
+    }
+    state = next
+  }
+
+  return state[n], nil
+}
+
+/* The number of ways to choose k things out of n.
+ */
+func binomial(n int, k int) float64 {
+  result := 1.0
+  for i := 1; i <= k; i++ {
+    result = result * float64(n - k + i) / float64(i)
+  }
+  return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+  "math"
+  "testing"
+)
+
+func closeTo(a float64, b float64) bool {
+  return math.Abs(a - b) < 1e-9
+}
+
+/* Test the distribution of plain dice and arithmetic */
+func TestAnalyzeBasic(t *testing.T) {
+  d, err := AnalyzeExpression("2d6", EvalOptions{})
+  if err != nil {
+    t.Fatalf("Analysing 2d6 failed with error: %s", err)
+  }
+
+  if d.Min != 2 || d.Max() != 12 {
+    t.Fatalf("Analyse 2d6: range was %d to %d instead of 2 to 12", d.Min, d.Max())
+  }
+  if !closeTo(d.Probability(7), 6.0/36) {
+    t.Fatalf("Analyse 2d6: P(7) was %f instead of %f", d.Probability(7), 6.0/36)
+  }
+  if !closeTo(d.Mean(), 7) {
+    t.Fatalf("Analyse 2d6: mean was %f instead of 7", d.Mean())
+  }
+  if !closeTo(d.StdDev(), math.Sqrt(35.0/6)) {
+    t.Fatalf("Analyse 2d6: standard deviation was %f instead of %f", d.StdDev(), math.Sqrt(35.0/6))
+  }
+  if !closeTo(d.AtLeast(10), 6.0/36) {
+    t.Fatalf("Analyse 2d6: P(>=10) was %f instead of %f", d.AtLeast(10), 6.0/36)
+  }
+}
+
+/* Test the mean of expressions using modifiers, functions and conditionals */
+func TestAnalyzeMeans(t *testing.T) {
+  cases := []struct {
+    expression string
+    mean float64
+  }{
+    {"d20 + 5", 15.5},
+    {"2d20!", 13.825},
+    {"2d20?", 7.175},
+    {"4d6kh3", 12.244598765432098},
+    {"4d6dl1", 12.244598765432098},
+    {"8d10>=8", 2.4},
+    {"10d6>4f1", 10.0/6},
+    {"d6ro1", 3.5 + 2.5/6},
+    {"d6r<3", 4.5},
+    {"d20 >= 11 ? 10 : 0", 5},
+    {"-d4", -2.5},
+    {"max(d4, d4)", 3.125},
+    {"floor(d6/2)", 1.5},
+    {"ceil(d6/2)", 2},
+    {"2 * 3", 6},
+    {"4dF+2", 2},
+    {"5dFkh4", 0.8641975308641975},
+  }
+
+  for _, c := range cases {
+    d, err := AnalyzeExpression(c.expression, EvalOptions{})
+    if err != nil {
+      t.Fatalf("Analysing %s failed with error: %s", c.expression, err)
+    }
+
+    if !closeTo(d.Mean(), c.mean) {
+      t.Fatalf("Analyse %s: mean was %f instead of %f", c.expression, d.Mean(), c.mean)
+    }
+
+    total := 0.0
+    for _, p := range d.Probabilities {
+      total += p
+    }
+    if !closeTo(total, 1) {
+      t.Fatalf("Analyse %s: probabilities add up to %f", c.expression, total)
+    }
+  }
+}
+
+/* Test that expressions which can't be analysed exactly give an error */
+func TestAnalyzeUnsupported(t *testing.T) {
+  for _, expression := range []string{"3d6!!", "10/(d2-1)", "4d6kh3>=4", "1 +", "d2*10000000"} {
+    if _, err := AnalyzeExpression(expression, EvalOptions{}); err == nil {
+      t.Fatalf("Analysing %s should have failed", expression)
+    }
+  }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+  "fmt"
+)
+
+// The Fate ladder, from Horrifying (-4) up to Legendary (+8)
+var fateLadder = []string{
+  "Horrifying",
+  "Catastrophic",
+  "Terrible",
+  "Poor",
+  "Mediocre",
+  "Average",
+  "Fair",
+  "Good",
+  "Great",
+  "Superb",
+  "Fantastic",
+  "Epic",
+  "Legendary",
+}
+
+// The rung of the Fate ladder that a result of 0 lands on
+const fateLadderZero = 4
+
+/* Names a result on the Fate ladder, ex. Great (+4).
+ * Results off either end of the ladder are described relative to it.
+ */
+func FateLadder(result int) string {
+  rung := result + fateLadderZero
+  switch {
+  case rung < 0:
+    return fmt.Sprintf("Beyond %s (%+d)", fateLadder[0], result)
+  case rung >= len(fateLadder):
+    return fmt.Sprintf("Beyond %s (%+d)", fateLadder[len(fateLadder)-1], result)
+  }
+  return fmt.Sprintf("%s (%+d)", fateLadder[rung], result)
+}
+
+/* The symbol shown for a face on a Fate die: + for +1, − for -1,
+ * and a blank for 0.
+ */
+func fateSymbol(face int) string {
+  switch {
+  case face > 0:
+    return "+"
+  case face < 0:
+    return "−"
+  }
+  return " "
+}
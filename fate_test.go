@@ -0,0 +1,24 @@
+package main
+
+import (
+  "testing"
+)
+
+/* Test naming results on the Fate ladder */
+func TestFateLadder(t *testing.T) {
+  cases := map[int]string{
+    4: "Great (+4)",
+    0: "Mediocre (+0)",
+    -1: "Poor (-1)",
+    -4: "Horrifying (-4)",
+    8: "Legendary (+8)",
+    9: "Beyond Legendary (+9)",
+    -6: "Beyond Horrifying (-6)",
+  }
+
+  for result, expected := range cases {
+    if FateLadder(result) != expected {
+      t.Fatalf("Fate ladder for %d was %q instead of %q", result, FateLadder(result), expected)
+    }
+  }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+)
+
+// The outcomes of a Forged in the Dark action roll, from best to worst
+const (
+  FitdCritical = "critical"
+  FitdSuccess = "success"
+  FitdPartial = "partial"
+  FitdBad = "bad"
+)
+
+// The positions and effect levels an action roll can be made with,
+// from best to worst position and from least to most effect
+var fitdPositions = []string{"controlled", "risky", "desperate"}
+var fitdEffects = []string{"limited", "standard", "great"}
+
+/* The result of a Forged in the Dark action roll.
+ * Result is the die that was kept, and Rolls are the dice behind it.
+ */
+type FitdResult struct {
+  Dice int
+  Result int
+  Outcome string
+  Rolls []DiceRoll
+}
+
+/* Makes a Forged in the Dark action roll with the given number of dice,
+ * keeping the highest. With zero dice, it rolls two and keeps the
+ * lowest instead, which can't be a critical. Two or more sixes are a
+ * critical, a 6 is a full success, a 4 or 5 is a partial success, and
+ * anything lower is a bad outcome.
+ */
+func RollFitd(dice int, options EvalOptions) (*FitdResult, error) {
+  if dice < 0 {
+    return nil, errors.New("You can't roll fewer than zero dice")
+  }
+
+  expression := fmt.Sprintf("%dd6kh1", dice)
+  if dice == 0 {
+    expression = "2d6kl1"
+  }
+  result, rolls, err := ParseExpressionWithOptions(expression, options)
+  if err != nil {
+    return nil, err
+  }
+
+  fitd := &FitdResult{Dice: dice, Result: result, Rolls: rolls}
+  sixes := 0
+  for _, face := range rolls[0].Results {
+    if face == 6 {
+      sixes++
+    }
+  }
+
+  switch {
+  case dice > 0 && sixes >= 2:
+    fitd.Outcome = FitdCritical
+  case result == 6:
+    fitd.Outcome = FitdSuccess
+  case result >= 4:
+    fitd.Outcome = FitdPartial
+  default:
+    fitd.Outcome = FitdBad
+  }
+  return fitd, nil
+}
+
+/* Describes what an outcome means at the given position.
+ */
+func fitdOutcomeText(outcome string, position string) string {
+  switch outcome {
+  case FitdCritical:
+    return "You do it with increased effect."
+  case FitdSuccess:
+    return "You do it."
+  case FitdPartial:
+    switch position {
+    case "controlled":
+      return "You hesitate. Withdraw and try a different approach, or else do it with a minor consequence: a minor complication occurs, you have reduced effect, you suffer lesser harm, you end up in a risky position."
+    case "desperate":
+      return "You do it, but there's a consequence: you suffer severe harm, a serious complication occurs, you have reduced effect."
+    }
+    return "You do it, but there's a consequence: you suffer harm, a complication occurs, you have reduced effect, you end up in a desperate position."
+  }
+
+  switch position {
+  case "controlled":
+    return "You falter. Press on by seizing a risky opportunity, or withdraw and try a different approach."
+  case "desperate":
+    return "It's the worst outcome. You suffer severe harm, a serious complication occurs, you lose this opportunity for action."
+  }
+  return "Things go badly. You suffer harm, a complication occurs, you end up in a desperate position, you lose this opportunity."
+}
+
+/* The effect level an outcome ends up with: a critical increases it
+ * by one level, up to great.
+ */
+func fitdEffect(outcome string, effect string) string {
+  if outcome != FitdCritical {
+    return effect
+  }
+  for i, e := range fitdEffects[:len(fitdEffects)-1] {
+    if e == effect {
+      return fitdEffects[i+1]
+    }
+  }
+  return effect
+}
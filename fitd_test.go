@@ -0,0 +1,60 @@
+package main
+
+import (
+  "testing"
+)
+
+/* Test Forged in the Dark action rolls against known rolls */
+func TestRollFitd(t *testing.T) {
+  cases := []struct {
+    dice int
+    faces []int
+    result int
+    outcome string
+  }{
+    {2, []int{6, 6}, 6, FitdCritical},
+    {3, []int{6, 2, 6}, 6, FitdCritical},
+    {2, []int{6, 3}, 6, FitdSuccess},
+    {1, []int{5}, 5, FitdPartial},
+    {3, []int{1, 4, 2}, 4, FitdPartial},
+    {2, []int{3, 1}, 3, FitdBad},
+    // With zero dice, the lowest of two is kept and two sixes aren't a critical
+    {0, []int{6, 6}, 6, FitdSuccess},
+    {0, []int{6, 2}, 2, FitdBad},
+  }
+
+  for _, c := range cases {
+    result, err := RollFitd(c.dice, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Rolling %d dice failed with error: %s", c.dice, err)
+    }
+    if result.Result != c.result || result.Outcome != c.outcome {
+      t.Fatalf("Rolling %v with %d dice: got %d (%s) instead of %d (%s)", c.faces, c.dice, result.Result, result.Outcome, c.result, c.outcome)
+    }
+  }
+
+  if _, err := RollFitd(-1, EvalOptions{}); err == nil {
+    t.Fatalf("Rolling fewer than zero dice should fail")
+  }
+}
+
+/* Test that only a critical increases the effect, and never past great */
+func TestFitdEffect(t *testing.T) {
+  cases := []struct {
+    outcome string
+    effect string
+    expected string
+  }{
+    {FitdCritical, "limited", "standard"},
+    {FitdCritical, "standard", "great"},
+    {FitdCritical, "great", "great"},
+    {FitdSuccess, "limited", "limited"},
+    {FitdBad, "standard", "standard"},
+  }
+
+  for _, c := range cases {
+    if effect := fitdEffect(c.outcome, c.effect); effect != c.expected {
+      t.Fatalf("A %s outcome with %s effect should have %s effect, got %s", c.outcome, c.effect, c.expected, effect)
+    }
+  }
+}
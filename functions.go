@@ -0,0 +1,63 @@
+package main
+
+import (
+  "math"
+  "slices"
+)
+
+/* A function that can be called from an expression, ex. max(1, d4-2)
+ * MaxArgs of -1 means the function takes any number of arguments.
+ * Rounding functions evaluate their argument with every division
+ * inside it rounded their way, and then return it unchanged.
+ * Functions that scale labels are also applied to the subtotal of
+ * each label inside them, ex. halve(3d6[fire]) halves the fire subtotal.
+ */
+type function struct {
+  minArgs int
+  maxArgs int
+  rounding string
+  scalesLabels bool
+  call func(args []int) (int, error)
+}
+
+/* Every function available in expressions, by name.
+ * To add a new function, add it here.
+ */
+var functions = map[string]function{
+  "min": {minArgs: 1, maxArgs: -1, call: func(args []int) (int, error) {
+    return slices.Min(args), nil
+  }},
+  "max": {minArgs: 1, maxArgs: -1, call: func(args []int) (int, error) {
+    return slices.Max(args), nil
+  }},
+  "abs": {minArgs: 1, maxArgs: 1, call: func(args []int) (int, error) {
+    if args[0] == math.MinInt {
+      return 0, &OverflowError{}
+    }
+    return max(args[0], -args[0]), nil
+  }},
+  "clamp": {minArgs: 3, maxArgs: 3, call: func(args []int) (int, error) {
+    return min(max(args[0], args[1]), args[2]), nil
+  }},
+  "halve": {minArgs: 1, maxArgs: 1, scalesLabels: true, call: func(args []int) (int, error) {
+    return divide(args[0], 2, "floor"), nil
+  }},
+  "double": {minArgs: 1, maxArgs: 1, scalesLabels: true, call: func(args []int) (int, error) {
+    if args[0] > math.MaxInt / 2 || args[0] < math.MinInt / 2 {
+      return 0, &OverflowError{}
+    }
+    return args[0] * 2, nil
+  }},
+  "floor": roundingFunction("floor"),
+  "ceil": roundingFunction("ceil"),
+  "round": roundingFunction("round"),
+  "truncate": roundingFunction("truncate"),
+}
+
+/* Builds a function that rounds the divisions in its argument with the given mode.
+ */
+func roundingFunction(mode string) function {
+  return function{minArgs: 1, maxArgs: 1, rounding: mode, call: func(args []int) (int, error) {
+    return args[0], nil
+  }}
+}
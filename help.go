@@ -0,0 +1,209 @@
+package main
+
+import (
+  "fmt"
+
+  "github.com/bwmarrin/discordgo"
+)
+
+/* A section of the help shown by /help-me-roll.
+ * Each topic is sent as its own message, so it must stay under
+ * Discord's limit of 2000 characters per message.
+ */
+type helpTopic struct {
+  Name string
+  Title string
+  Text string
+}
+
+var helpTopics = []helpTopic{
+  {
+    Name: "basics",
+    Title: "🎲 Basic Usage  🎲",
+    Text: `**/roll** <expression>
+- Example usage: ` + "`" + `/roll 4d10 + 5` + "`" + `
+- You can give it any arithmetic expression with both numbers and dice notation.
+- Dice notation must be in the form XdY, where X and Y are integers.
+- Negative numbers work too, e.g. ` + "`" + `d20 + -2` + "`" + ` or ` + "`" + `-1 + d20` + "`" + `.
+- For advantage and disadvantage, you can write ! or ? after your dice notation to get the highest and lowest roll respectively. For example, 4d10! will get the highest of the four rolls, while 4d10? will get the lowest.
+- You can roll up to d200 and up to 20 rolls at once.
+- To roll the same thing several times, write ` + "`" + `6x 4d6kh3` + "`" + ` or ` + "`" + `repeat(6, 4d6kh3)` + "`" + `. Each row is rolled separately, up to 20 times. Add sort:descending to sort the results.
+- Make several rolls at once by separating them with semicolons, and label them with a name and a colon, e.g. ` + "`" + `Attack: d20+7; Damage: 2d6+4 [slashing]` + "`" + `.
+- Label part of a roll by writing a label in square brackets after it, e.g. ` + "`" + `d6[fire]` + "`" + `. A label at the end after a space labels the whole roll. Labels can't be used in comparisons, or inside functions like max().`,
+  },
+  {
+    Name: "dice",
+    Title: "🎲 Dice Modifiers  🎲",
+    Text: `Modifiers are written directly after dice notation, with no spaces.
+- To keep or drop some of the dice, use kh (keep highest), kl (keep lowest), dh (drop highest) or dl (drop lowest) followed by how many. For example, 4d6kh3 or 4d6dl1 rolls four d6 and drops the lowest.
+- For exploding dice, write !! or e after your dice notation, e.g. 3d6!! or d6e. Every die that rolls its highest face is rolled again and added on. Add c to compound the extra rolls into one die (d6!!c), p for penetrating dice that subtract 1 from each extra roll (d6!!p), or a threshold to explode on more faces (d10e>=9).
+- To reroll dice, write r followed by the faces to reroll, e.g. 4d6r<2 rerolls until every die is 2 or higher. Use ro to only reroll once, e.g. 2d6ro1 rerolls 1s a single time.
+- To count successes instead of adding up the dice, give a target directly after your dice notation with no space, e.g. 8d10>=8 counts how many dice rolled 8 or higher. Add f and a target to subtract failures, e.g. 10d6>4f1 takes away a success for every 1.`,
+  },
+  {
+    Name: "math",
+    Title: "🎲 Math and Functions  🎲",
+    Text: `- You can use the functions min, max, abs and clamp, e.g. ` + "`" + `max(1, d4-2)` + "`" + ` so the result is never below 1, or ` + "`" + `clamp(d20+5, 1, 20)` + "`" + `.
+- Use halve() and double() for resistance and vulnerability. They also change the subtotals of any labels inside them, e.g. ` + "`" + `2d6[slashing] + halve(3d6[fire]) + 4` + "`" + ` shows the slashing and halved fire damage separately.
+- You can compare with <, <=, >, >=, == and != (giving 1 or 0), and pick between two results with ` + "`" + `condition ? then : else` + "`" + `, e.g. ` + "`" + `d20+5 >= 15 ? 2d6+3 : 0` + "`" + `. Only the chosen side's dice are rolled. Put a space between dice and a comparison, since ` + "`" + `2d6>=10` + "`" + ` counts the dice that rolled 10 or more.
+- Division rounds down by default. Wrap part of your expression in floor(), ceil(), round() or truncate() to round the divisions inside it differently, e.g. ` + "`" + `ceil(d6/2)` + "`" + `. Members who can manage your server can use **/set-rounding** to change the default for it.`,
+  },
+  {
+    Name: "macros",
+    Title: "🎲 Macros  🎲",
+    Text: `A macro is an expression you can re-use again and again. Macros can have inputs, which must be written as uppercase letters starting from A. If the macro only has one input, it must be named A; two, must be named A and B, and so on. An F straight after a d is always a Fate die (` + "`" + `4dF` + "`" + `), not an input.
+
+For example, you can have a macro: ` + "`" + `4 * (A + B)` + "`" + `
+You will be able to roll this macro substituting anything you'd like for the variables A and B.
+
+**/make-macro** <name> <expression>
+- This is used to create a macro. For example: ` + "`" + `/make-macro my-macro 4 * (A + B)` + "`" + `
+- Macros can be named anything, with a maximum of 128 characters.
+
+**/roll-macro** <name> <inputs separated by spaces>
+- This is how you roll a macro once it's created. Specify the name of the macro, following by what you want the A, B, C, etc to be separated by spaces. (They can be either numbers or dice notation.)
+- For example: ` + "`" + `/roll-macro my-macro 10 4d6` + "`" + `
+
+There are several other commands to help you view, edit, and delete macros: 
+**/list-macros** | Lists all macros available.
+**/view-macro** <name> | Displays the macro with the given name.
+**/delete-macro** <name> | Deletes the macro with the given name.
+**/edit-macro** <name> <expression> | Updates the existing macro.
+
+Macros are tied to the server and macros created by this server can only be used in this server.`,
+  },
+  {
+    Name: "fate",
+    Title: "🎲 Fate Dice  🎲",
+    Text: `- Roll Fate (Fudge) dice with dF, e.g. ` + "`" + `/roll 4dF+2` + "`" + `. Each die shows + (+1), − (-1) or a blank (0).
+- Fate dice can be kept or dropped like other dice, e.g. ` + "`" + `5dFkh4` + "`" + `, but can't explode, be rerolled or count successes.
+- Set fate-ladder to True to name the result on the Fate ladder, from Horrifying (-4) up to Legendary (+8), e.g. Great (+4).`,
+  },
+  {
+    Name: "attack",
+    Title: "🎲 Attacks  🎲",
+    Text: `**/attack** <to-hit> <damage> [crit-on]
+- Rolls to hit and then rolls damage, e.g. ` + "`" + `/attack to-hit:+7 damage:2d6+4` + "`" + `
+- The to-hit can be just your bonus, which is added to a d20, e.g. ` + "`" + `+5+d4` + "`" + ` with Bless, or a whole roll with a d20 in it, e.g. ` + "`" + `2d20kh1+7` + "`" + ` for advantage.
+- A natural 20 is a critical hit, which doubles the damage dice but not the numbers added to them, so 2d6+4 becomes 4d6+4. Set crit-on to crit on more rolls, e.g. crit-on:19 for 19-20.
+- A natural 1 is a fumble, and no damage is rolled.
+- Labels and halve() work in the damage too, e.g. ` + "`" + `1d8[piercing] + 2d6[fire] + 3` + "`" + `.`,
+  },
+  {
+    Name: "coc",
+    Title: "🎲 Call of Cthulhu  🎲",
+    Text: `**/coc** <skill> [bonus] [penalty]
+- Makes a percentile roll against your skill, e.g. ` + "`" + `/coc skill:60 bonus:1` + "`" + `
+- The tens and units dice are shown separately. Each bonus die rolls another tens die and keeps the best, and each penalty die keeps the worst. Bonus and penalty dice cancel each other out, up to 2 of either.
+- The result is graded as a critical (01), extreme (a fifth of your skill or less), hard (half or less), regular, failure, or fumble (100, or 96-100 when your skill is under 50).`,
+  },
+  {
+    Name: "bands",
+    Title: "🎲 Result Bands  🎲",
+    Text: `Bands give names to ranges of results, like the strong hit, weak hit and miss of Powered by the Apocalypse games.
+
+**/roll** <expression> bands:<name>
+- Names the result of your roll, e.g. ` + "`" + `/roll 2d6+1 bands:pbta` + "`" + ` shows whether you got a strong hit (10+), weak hit (7-9) or miss (6-).
+
+**/make-bands** <name> <bands>
+- Creates your own bands. Write each range followed by its name, separated by semicolons, e.g. ` + "`" + `/make-bands check 15+ Success; 10-14 Partial; 9- Failure` + "`" + `
+- A range can be N+ (N or more), N- (N or less), A-B, or a single number. If ranges overlap, the first one wins.
+
+**/list-bands** | Lists the built-in bands and your server's bands.
+**/delete-bands** <name> | Deletes your server's bands with the given name.`,
+  },
+  {
+    Name: "fitd",
+    Title: "🎲 Forged in the Dark  🎲",
+    Text: `**/fitd** <dice> [position] [effect]
+- Makes an action roll for Blades in the Dark and other Forged in the Dark games, e.g. ` + "`" + `/fitd dice:3 position:desperate effect:great` + "`" + `
+- Rolls that many d6 and keeps the highest. With 0 dice, rolls 2d6 and keeps the lowest.
+- A 6 is a full success, a 4 or 5 is a partial success, and 1-3 is a bad outcome. Two or more 6s are a critical, which also increases your effect.
+- Position defaults to risky and effect to standard. The outcome text tells you what happens at your position.`,
+  },
+  {
+    Name: "genesys",
+    Title: "🎲 Genesys and Star Wars  🎲",
+    Text: `**/genesys** <pool>
+- Rolls a pool of narrative dice, e.g. ` + "`" + `/genesys pool:2a 1p 2d 1s` + "`" + `
+- Each die is a number followed by its name or letter: **p**roficiency, **a**bility, **b**oost, **c**hallenge, **d**ifficulty, **s**etback and **f**orce, e.g. ` + "`" + `3a` + "`" + ` or ` + "`" + `3 ability` + "`" + `.
+- Each success cancels a failure, and each advantage cancels a threat. The check succeeds if there's at least one success left.
+- Triumphs also count as a success and despairs as a failure, but they're never cancelled. Light and dark side points from force dice are reported separately.`,
+  },
+  {
+    Name: "custom-dice",
+    Title: "🎲 Custom dice  🎲",
+    Text: `**/make-die** <name> <faces>
+- Makes a die with its own faces, separated by commas, e.g. ` + "`" + `/make-die name:avalanche faces:0, 0, 1, 1, 2, 3` + "`" + `
+- Roll it in any expression or macro by putting its name in braces, e.g. ` + "`" + `3d{avalanche}+2` + "`" + `
+- Faces can be numbers, which add up like normal dice, or names, which are tallied after the roll, e.g. ` + "`" + `/make-die name:location faces:head, torso, left arm, right arm, legs, legs` + "`" + `
+- A face can have both, or several names, joined with +, e.g. ` + "`" + `2+hit` + "`" + ` or ` + "`" + `hit+bleed` + "`" + `. Write ` + "`" + `blank` + "`" + ` for a face with nothing on it.
+- Custom dice can be kept or dropped like other dice, e.g. ` + "`" + `4d{avalanche}kh2` + "`" + `, and are doubled on a critical hit.
+- /odds can't work out custom dice exactly yet, but /simulate can.
+
+**/list-dice**
+- Lists the custom dice made in this server.
+
+**/delete-die** <name>
+- Deletes a custom die.`,
+  },
+  {
+    Name: "odds",
+    Title: "🎲 Odds  🎲",
+    Text: `**/odds** <expression> [target]
+- Works out the exact odds of an expression without rolling it, e.g. ` + "`" + `/odds 4d6kh3 target:15` + "`" + `
+- Shows the average result, how spread out the results are (standard deviation), the lowest and highest possible results, and the chance of rolling at least the target.
+- Exploding dice can't be worked out exactly.
+
+**/compare-rolls** <first> <second> [third] [fourth] [png]
+- Draws the odds of up to four expressions on one chart, e.g. ` + "`" + `/compare-rolls first:2d20! second:d20+3` + "`" + `
+- The chart is drawn with text by default. Set png to True to get an image instead.
+
+**/simulate** <expression> [iterations] [target]
+- Rolls an expression many times and shows the average, the spread of results and how often you rolled at least the target, e.g. ` + "`" + `/simulate 6d6!! iterations:100000 target:25` + "`" + `
+- Works with anything you can roll, including exploding dice. Up to a million rolls, for at most a couple of seconds.`,
+  },
+  {
+    Name: "fair-rolls",
+    Title: "🎲 Fair Rolls  🎲",
+    Text: `**/roll** <expression> receipt:True
+- Attaches a signed receipt to your roll. Anyone can paste it into **/verify-roll** later to check the roll really happened and wasn't changed.
+
+**/set-secure-rolls** <enabled>
+- Makes every roll in your server use a cryptographically secure random source, and attaches a receipt to every roll. Only members who can manage the server can change this.`,
+  },
+}
+
+/* Lists the help topics as choices for the /help-me-roll command.
+ */
+func helpTopicChoices() []*discordgo.ApplicationCommandOptionChoice {
+  choices := []*discordgo.ApplicationCommandOptionChoice{}
+  for _, t := range helpTopics {
+    choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: t.Name, Value: t.Name})
+  }
+  return choices
+}
+
+/* Builds the help message for the given topic.
+ * With no topic, shows the basics along with a list of the other topics.
+ */
+func helpMessage(topic string) string {
+  if topic == "" {
+    topic = helpTopics[0].Name
+  }
+
+  message := "**DiceMancer Bot Available Commands**\n\n"
+  for _, t := range helpTopics {
+    if t.Name == topic {
+      message += fmt.Sprintf("%s\n%s\n\n", t.Title, t.Text)
+    }
+  }
+
+  message += "More help is available with **/help-me-roll** <topic>, for these topics:"
+  for _, t := range helpTopics {
+    message += " `" + t.Name + "`"
+  }
+
+  message += "\n\nPlease enjoy using DiceMancer, and feel free to contact the developer <@284867832376721409> if you have further questions or comments.\n"
+  return message
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+  "testing"
+  "unicode/utf8"
+)
+
+/* Test that every help topic fits in a single Discord message */
+func TestHelpMessageLength(t *testing.T) {
+  for _, topic := range helpTopics {
+    length := utf8.RuneCountInString(helpMessage(topic.Name))
+    if length > 2000 {
+      t.Fatalf("Help for %s is %d characters, over Discord's limit of 2000", topic.Name, length)
+    }
+  }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "unicode"
+)
+
+/* The different kinds of token the lexer can produce.
+ */
+type TokenKind int
+
+const (
+  // An integer, ex. 5
+  TokenNumber TokenKind = iota
+  // Dice notation without any modifiers, ex. 4d6, d20 or 4dF
+  TokenDice
+  // A modifier written directly after dice notation, ex. kh, !!, ro
+  TokenModifier
+  // A comparison operator: = == != < <= > >=
+  TokenComparison
+  // An arithmetic operator: + - * /
+  TokenOperator
+  // The name of a function, ex. floor
+  TokenIdentifier
+  TokenLeftParen
+  TokenRightParen
+  // Separates the arguments to a function
+  TokenComma
+  // The ? and : of a conditional, ex. d20 >= 15 ? 2d6 : 0
+  TokenQuestion
+  TokenColon
+  // A label in square brackets, ex. [fire]. Text is the label without the brackets
+  TokenLabel
+  // Marks the end of the input
+  TokenEOF
+)
+
+/* A single token from an expression.
+ * Pos is the index of the token's first character in the input, and
+ * Spaced records if there was whitespace directly before the token.
+ */
+type Token struct {
+  Kind TokenKind
+  Text string
+  Pos int
+  Spaced bool
+}
+
+/* Error returned when an expression cannot be lexed or parsed.
+ * Column is 1-based, and points at the character that failed.
+ */
+type ParseError struct {
+  Column int
+  Message string
+}
+
+func (e *ParseError) Error() string {
+  return fmt.Sprintf("Unable to parse at column %d: %s", e.Column, e.Message)
+}
+
+/* Builds a ParseError for the character at the given index.
+ */
+func parseErrorAt(pos int, format string, args ...any) *ParseError {
+  return &ParseError{Column: pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+// Modifiers that can follow dice notation, longest first so that
+// the lexer always picks the longest match.
+var diceModifiers = []string{
+  "!!c", "!!p", "!!", "ec", "ep", "e",
+  "kh", "kl", "dh", "dl",
+  "ro", "r",
+  "f",
+  "!", "?",
+}
+
+/* Converts the input string into an array of typed tokens,
+ * always ending with a TokenEOF.
+ * Dice modifiers are only recognised directly after dice notation
+ * (or after another modifier), since letters like e and f mean
+ * nothing anywhere else. This also means a ? directly after dice is
+ * disadvantage, while anywhere else it starts a conditional.
+ */
+func lex(input string) ([]Token, error) {
+  runes := []rune(input)
+  tokens := []Token{}
+  inDice := false
+  spaced := false
+
+  for i := 0; i < len(runes); {
+    r := runes[i]
+    start := i
+
+    switch {
+    case unicode.IsSpace(r):
+      i++
+      inDice = false
+      spaced = true
+      continue
+    case isDigit(r) || (r == 'd' && i+1 < len(runes) && isDiceSides(runes[i+1]) && !inDice):
+      for i < len(runes) && isDigit(runes[i]) {
+        i++
+      }
+      if !inDice && i+1 < len(runes) && runes[i] == 'd' && isDiceSides(runes[i+1]) {
+        i++
+        if isDigit(runes[i]) {
+          for i < len(runes) && isDigit(runes[i]) {
+            i++
+          }
+        } else if runes[i] == '{' {
+          end := i + 1
+          for end < len(runes) && runes[end] != '}' {
+            end++
+          }
+          if end == len(runes) {
+            return tokens, parseErrorAt(i, "found '{' without a matching '}'")
+          }
+          i = end + 1
+        } else {
+          i++
+        }
+        tokens = append(tokens, Token{Kind: TokenDice, Text: string(runes[start:i]), Pos: start, Spaced: spaced})
+        inDice = true
+      } else {
+        tokens = append(tokens, Token{Kind: TokenNumber, Text: string(runes[start:i]), Pos: start, Spaced: spaced})
+        // An x straight after a number repeats a roll, ex. 6x4d6
+        if !inDice && i < len(runes) && runes[i] == 'x' {
+          tokens = append(tokens, Token{Kind: TokenIdentifier, Text: "x", Pos: i})
+          i++
+        }
+      }
+    case r == '<' || r == '>' || r == '=' || (r == '!' && i+1 < len(runes) && runes[i+1] == '='):
+      i++
+      if i < len(runes) && runes[i] == '=' {
+        i++
+      }
+      tokens = append(tokens, Token{Kind: TokenComparison, Text: string(runes[start:i]), Pos: start, Spaced: spaced})
+    case inDice && matchModifier(runes[i:]) != "":
+      modifier := matchModifier(runes[i:])
+      i += len([]rune(modifier))
+      tokens = append(tokens, Token{Kind: TokenModifier, Text: modifier, Pos: start, Spaced: spaced})
+    case !inDice && unicode.IsLetter(r):
+      for i < len(runes) && (unicode.IsLetter(runes[i]) || isDigit(runes[i])) {
+        i++
+      }
+      tokens = append(tokens, Token{Kind: TokenIdentifier, Text: string(runes[start:i]), Pos: start, Spaced: spaced})
+    case strings.ContainsRune("+-*/", r):
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenOperator, Text: string(r), Pos: start, Spaced: spaced})
+    case r == '(':
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenLeftParen, Text: "(", Pos: start, Spaced: spaced})
+    case r == ')':
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenRightParen, Text: ")", Pos: start, Spaced: spaced})
+    case r == '?':
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenQuestion, Text: "?", Pos: start, Spaced: spaced})
+    case r == ':':
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenColon, Text: ":", Pos: start, Spaced: spaced})
+    case r == '[':
+      end := i + 1
+      for end < len(runes) && runes[end] != ']' {
+        end++
+      }
+      if end == len(runes) {
+        return tokens, parseErrorAt(i, "found '[' without a matching ']'")
+      }
+      label := strings.TrimSpace(string(runes[i+1:end]))
+      if label == "" {
+        return tokens, parseErrorAt(i, "labels can't be empty")
+      }
+      i = end + 1
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenLabel, Text: label, Pos: start, Spaced: spaced})
+    case r == ',':
+      i++
+      inDice = false
+      tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Pos: start, Spaced: spaced})
+    default:
+      return tokens, parseErrorAt(i, "unexpected character '%c'", r)
+    }
+    spaced = false
+  }
+
+  tokens = append(tokens, Token{Kind: TokenEOF, Pos: len(runes), Spaced: spaced})
+  return tokens, nil
+}
+
+/* Checks whether a character is one of the digits 0 to 9. Other
+ * scripts' digits aren't numbers in expressions.
+ */
+func isDigit(r rune) bool {
+  return r >= '0' && r <= '9'
+}
+
+/* Checks if a character can follow the d in dice notation: either
+ * the number of sides, F for Fate dice, or { for a custom die's name.
+ */
+func isDiceSides(r rune) bool {
+  return isDigit(r) || r == 'F' || r == 'f' || r == '{'
+}
+
+/* Returns the dice modifier at the start of the input, if there is one.
+ */
+func matchModifier(input []rune) string {
+  for _, modifier := range diceModifiers {
+    if strings.HasPrefix(string(input), modifier) {
+      return modifier
+    }
+  }
+  return ""
+}
@@ -29,7 +29,7 @@ func InitDB() {
     log.Fatal(err)
   }
 
-  db.AutoMigrate(&Macro{})
+  db.AutoMigrate(&Macro{}, &GuildSettings{}, &BandSet{}, &NamedDie{})
 }
 
 func FindMacro(guild string, name string) (*Macro, error) {
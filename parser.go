@@ -1,252 +1,1190 @@
 package main
 
 import (
+  "cmp"
+  "errors"
   "fmt"
+  "math"
   "regexp"
-  "strings"
-  "strconv"
-  "math/rand"
-  "time"
-  "errors"
   "slices"
+  "strconv"
+  "strings"
 )
 
-/* Struct representing the result(s) of a dice roll
+/* Error returned when an expression divides by zero.
+ * Column is 1-based, and points at the / that failed.
+ */
+type DivisionByZeroError struct {
+  Column int
+}
+
+func (e *DivisionByZeroError) Error() string {
+  return fmt.Sprintf("Unable to calculate at column %d: division by zero", e.Column)
+}
+
+/* Error returned when a result is too large to be represented.
+ * Column is 1-based, and points at the operator that overflowed.
+ */
+type OverflowError struct {
+  Column int
+}
+
+func (e *OverflowError) Error() string {
+  return fmt.Sprintf("Unable to calculate at column %d: result is too large", e.Column)
+}
+
+/* A node in the syntax tree of a parsed expression.
+ * Evaluating a node rolls any dice beneath it.
+ */
+type Node interface {
+  eval(e *evaluator) (int, error)
+}
+
+/* An integer written in the expression, ex. 5
+ */
+type NumberNode struct {
+  Value int
+}
+
+/* Dice notation along with its modifiers, ex. 4d6kh3
+ * Expression is the notation exactly as it was written.
  */
-type DiceRoll struct {
+type DiceNode struct {
   Expression string
-  Results []int
+  spec diceSpec
+  // The index of the success target's comparison, ex. the >= in 8d10>=7
+  targetPos int
+}
+
+/* An arithmetic operation on two sub-expressions, ex. 2d6 + 3
+ * Pos is the index of the operator in the input.
+ */
+type BinaryNode struct {
+  Op string
+  Left Node
+  Right Node
+  Pos int
+}
+
+/* A unary + or - applied to a sub-expression, ex. -2 or -(d4 + 1)
+ */
+type UnaryNode struct {
+  Op string
+  Operand Node
+  Pos int
+}
+
+/* A comparison between two sub-expressions, ex. d20+5 >= 15
+ * Evaluates to 1 if the comparison holds, and 0 otherwise.
+ */
+type ComparisonNode struct {
+  Op string
+  Left Node
+  Right Node
+}
+
+/* Picks between two sub-expressions, ex. d20 >= 15 ? 2d6 : 0
+ * Only the chosen sub-expression is evaluated, so only its dice are rolled.
+ */
+type ConditionalNode struct {
+  Condition Node
+  Then Node
+  Else Node
+}
+
+/* A call to one of the functions in the functions registry, ex. max(1, d4-2)
+ * Pos is the index of the function's name in the input.
+ */
+type FunctionNode struct {
+  Name string
+  Args []Node
+  Pos int
+}
+
+/* A part of an expression with a label in square brackets, ex. d6[fire]
+ * Any dice rolled inside it are given the label.
+ */
+type LabelNode struct {
+  Label string
+  Operand Node
+}
+
+// The ways a division can be rounded, by the name used for them in expressions
+var roundingModes = []string{"floor", "ceil", "round", "truncate"}
+
+/* Options that change how an expression is evaluated.
+ */
+type EvalOptions struct {
+  // How a plain / rounds when it isn't inside floor(), ceil() etc.
+  // One of "floor", "ceil", "round" or "truncate"; defaults to "floor".
+  Rounding string
+  // Where the dice rolls come from; defaults to a shared, randomly seeded Roller.
+  Roller Roller
+  // Roll twice as many of every dice, as for a critical hit, keeping or
+  // dropping twice as many too. Numbers written in the expression aren't doubled.
+  Critical bool
+  // The custom dice that can be rolled with d{name}, by lowercase name
+  CustomDice map[string]CustomDie
 }
 
-/* Randomly rolls based on the given dice notation
+/* Holds the state built up while evaluating an expression,
+ * namely the results of every dice roll made so far, the subtotal
+ * of each label, and how divisions are currently being rounded and labelled.
  */
-func rollDice(diceNotation string) (int, []int) {
-  lastChar := diceNotation[len(diceNotation)-1]
-  mode := "sum"
-  if lastChar == '!' {
-    mode = "highest"
-    diceNotation = diceNotation[:len(diceNotation)-1]
-  } else if lastChar == '?' {
-    mode = "lowest"
-    diceNotation = diceNotation[:len(diceNotation)-1]
+type evaluator struct {
+  rolls []DiceRoll
+  rounding string
+  roller Roller
+  critical bool
+  customDice map[string]CustomDie
+  label string
+  // The subtotal of each label so far, in the order they were first used
+  labels []LabelTotal
+}
+
+func (n NumberNode) eval(e *evaluator) (int, error) {
+  return n.Value, nil
+}
+
+func (n DiceNode) eval(e *evaluator) (int, error) {
+  spec := n.spec
+  if e.critical {
+    spec.count *= 2
+    spec.keepCount *= 2
   }
-  
-  parts := strings.Split(diceNotation, "d")
 
-  numRolls, _ := strconv.Atoi(parts[0])
-  sides, _ := strconv.Atoi(parts[1])
+  if spec.custom != "" {
+    die, ok := e.customDice[spec.custom]
+    if !ok {
+      return 0, errors.New(fmt.Sprintf("There's no custom die named '%s'", spec.custom))
+    }
+    result, rolls, faces, flags := rollCustomDice(spec, die, e.roller)
+    e.rolls = append(e.rolls, DiceRoll{
+      Expression: n.Expression,
+      Sides: len(die.Faces),
+      Results: rolls,
+      Flags: flags,
+      Label: e.label,
+      Faces: faces,
+    })
+    return result, nil
+  }
+
+  result, rolls, flags := rollDice(spec, e.roller)
+  e.rolls = append(e.rolls, DiceRoll{
+    Expression: n.Expression,
+    Sides: n.spec.sides,
+    Results: rolls,
+    Flags: flags,
+    Label: e.label,
+    Fate: n.spec.fate,
+  })
+  return result, nil
+}
+
+func (n LabelNode) eval(e *evaluator) (int, error) {
+  outer := e.label
+  e.label = n.Label
+  defer func() { e.label = outer }()
 
-  if sides < 1 || sides > 200 || numRolls < 1 || numRolls > 20 {
-    return 0, []int{}
+  labelledBefore := e.labelled()
+  value, err := n.Operand.eval(e)
+  if err != nil {
+    return 0, err
   }
 
-  rand.Seed(time.Now().UnixNano())
+  // Whatever inside wasn't already counted towards an inner label
+  // counts towards this one
+  e.addToLabel(n.Label, value - (e.labelled() - labelledBefore))
+  return value, nil
+}
 
-  rolls := []int{}
-  result := 0
-  for i := 0; i < numRolls; i++ {
-    rollValue := rand.Intn(sides) + 1
-    rolls = append(rolls, rollValue)
+/* How much of the expression so far has been counted towards a label.
+ */
+func (e *evaluator) labelled() int {
+  total := 0
+  for _, l := range e.labels {
+    total += l.Total
+  }
+  return total
+}
 
-    switch mode {
-    case "sum":
-      result += rollValue
-    case "highest":
-      if rollValue > result {
-        result = rollValue
-      }
-    case "lowest":
-      if result == 0 || rollValue < result {
-        result = rollValue
+/* Adds an amount to the subtotal of a label.
+ */
+func (e *evaluator) addToLabel(label string, amount int) {
+  for i := range e.labels {
+    if e.labels[i].Label == label {
+      e.labels[i].Total += amount
+      return
+    }
+  }
+  e.labels = append(e.labels, LabelTotal{Label: label, Total: amount})
+}
+
+/* Replaces how much each label's subtotal grew by since before with
+ * the result of f, ex. halving everything labelled inside halve().
+ */
+func (e *evaluator) adjustLabels(before []LabelTotal, f func(grown int) (int, error)) error {
+  for i := range e.labels {
+    previous := labelTotalAt(before, i)
+    adjusted, err := f(e.labels[i].Total - previous)
+    if err != nil {
+      return err
+    }
+    e.labels[i].Total = previous + adjusted
+  }
+  return nil
+}
+
+/* Applies an operator to the subtotal of each label, given the labels
+ * before the left operand and between the two operands. The parser only
+ * allows labels on one side of * and on the left of /, so each label
+ * grows by the operator applied to the part of it on that side.
+ */
+func (e *evaluator) combineLabels(n BinaryNode, beforeLeft []LabelTotal, beforeRight []LabelTotal, left int, right int) error {
+  if n.Op == "+" {
+    return nil
+  }
+  for i := range e.labels {
+    previous := labelTotalAt(beforeLeft, i)
+    middle := labelTotalAt(beforeRight, i)
+    fromLeft, fromRight := middle - previous, e.labels[i].Total - middle
+
+    var grown int
+    var err error
+    switch n.Op {
+    case "-":
+      grown, err = applyOperator("-", fromLeft, fromRight, e.rounding, n.Pos)
+    case "*":
+      if fromLeft != 0 {
+        grown, err = applyOperator("*", fromLeft, right, e.rounding, n.Pos)
+      } else {
+        grown, err = applyOperator("*", left, fromRight, e.rounding, n.Pos)
       }
+    case "/":
+      grown, err = applyOperator("/", fromLeft, right, e.rounding, n.Pos)
+    }
+    if err != nil {
+      return err
+    }
+    e.labels[i].Total = previous + grown
+  }
+  return nil
+}
+
+/* The subtotal of the label at index i, or 0 if there wasn't one yet.
+ */
+func labelTotalAt(labels []LabelTotal, i int) int {
+  if i < len(labels) {
+    return labels[i].Total
+  }
+  return 0
+}
+
+/* Checks if a part of an expression has a label anywhere inside it.
+ */
+func hasLabel(node Node) bool {
+  switch n := node.(type) {
+  case LabelNode:
+    return true
+  case UnaryNode:
+    return hasLabel(n.Operand)
+  case BinaryNode:
+    return hasLabel(n.Left) || hasLabel(n.Right)
+  case ComparisonNode:
+    return hasLabel(n.Left) || hasLabel(n.Right)
+  case ConditionalNode:
+    return hasLabel(n.Condition) || hasLabel(n.Then) || hasLabel(n.Else)
+  case FunctionNode:
+    return slices.ContainsFunc(n.Args, hasLabel)
+  }
+  return false
+}
+
+func (n UnaryNode) eval(e *evaluator) (int, error) {
+  labelsBefore := slices.Clone(e.labels)
+  value, err := n.Operand.eval(e)
+  if err != nil {
+    return 0, err
+  }
+
+  err = e.adjustLabels(labelsBefore, func(grown int) (int, error) {
+    return applyUnary(n.Op, grown, n.Pos)
+  })
+  if err != nil {
+    return 0, err
+  }
+  return applyUnary(n.Op, value, n.Pos)
+}
+
+/* Applies a unary + or - to a value.
+ * Pos is the index of the operator, used to report overflow.
+ */
+func applyUnary(op string, value int, pos int) (int, error) {
+  if op == "-" {
+    if value == math.MinInt {
+      return 0, &OverflowError{Column: pos + 1}
+    }
+    return -value, nil
+  }
+  return value, nil
+}
+
+func (n ComparisonNode) eval(e *evaluator) (int, error) {
+  left, err := n.Left.eval(e)
+  if err != nil {
+    return 0, err
+  }
+  right, err := n.Right.eval(e)
+  if err != nil {
+    return 0, err
+  }
+
+  if (comparison{op: n.Op, target: right}).matches(left) {
+    return 1, nil
+  }
+  return 0, nil
+}
+
+func (n ConditionalNode) eval(e *evaluator) (int, error) {
+  condition, err := n.Condition.eval(e)
+  if err != nil {
+    return 0, err
+  }
+
+  if condition != 0 {
+    return n.Then.eval(e)
+  }
+  return n.Else.eval(e)
+}
+
+func (n FunctionNode) eval(e *evaluator) (int, error) {
+  fn := functions[n.Name]
+  if fn.rounding != "" {
+    outerRounding := e.rounding
+    e.rounding = fn.rounding
+    defer func() { e.rounding = outerRounding }()
+  }
+
+  labelsBefore := slices.Clone(e.labels)
+
+  args := []int{}
+  for _, arg := range n.Args {
+    value, err := arg.eval(e)
+    if err != nil {
+      return 0, err
+    }
+    args = append(args, value)
+  }
+
+  result, err := callFunction(fn, args, n.Pos)
+  if err != nil {
+    return 0, err
+  }
+  if fn.scalesLabels {
+    err := e.adjustLabels(labelsBefore, func(grown int) (int, error) {
+      return callFunction(fn, []int{grown}, n.Pos)
+    })
+    if err != nil {
+      return 0, err
+    }
+  }
+  return result, nil
+}
+
+/* Calls a function with already evaluated arguments.
+ * Pos is the index of the function's name, used to report overflow.
+ */
+func callFunction(fn function, args []int, pos int) (int, error) {
+  result, err := fn.call(args)
+  if err != nil {
+    var overflowError *OverflowError
+    if errors.As(err, &overflowError) {
+      return 0, &OverflowError{Column: pos + 1}
+    }
+    return 0, err
+  }
+  return result, nil
+}
+
+/* Divides two integers, rounding the result with the given mode:
+ * - floor rounds down, ex. -7/2 is -4
+ * - ceil rounds up, ex. 7/2 is 4
+ * - round rounds to the nearest integer, with halves rounding up
+ * - truncate rounds toward zero, ex. -7/2 is -3
+ * The divisor must not be zero.
+ */
+func divide(left int, right int, rounding string) int {
+  quotient := left / right
+  remainder := left % right
+  if remainder == 0 {
+    return quotient
+  }
+
+  // The exact result lies between quotient and quotient+1 when it is
+  // positive, or quotient-1 and quotient when it is negative
+  negative := (remainder < 0) != (right < 0)
+  switch rounding {
+  case "truncate":
+    return quotient
+  case "ceil":
+    if !negative {
+      return quotient + 1
+    }
+    return quotient
+  case "round":
+    // Compare the fractional part against a half, without overflowing
+    remainderSize, divisorSize := absUint(remainder), absUint(right)
+    atLeastHalf := remainderSize >= divisorSize - remainderSize
+    moreThanHalf := remainderSize > divisorSize - remainderSize
+    if !negative && atLeastHalf {
+      return quotient + 1
+    }
+    if negative && moreThanHalf {
+      return quotient - 1
     }
+    return quotient
+  default:
+    if negative {
+      return quotient - 1
+    }
+    return quotient
   }
-  
-  return result, rolls
 }
 
-/* Given two operators, op1 and op2, checks if op1 has greater precedence.
- * Returns true if op1 has greater precedence (should come first)
+/* Returns the absolute value of n, which cannot overflow as a uint.
  */
-func hasGreaterPrecedence(op1 string, op2 string) bool {
-  precedence := map[string]int{"+": 1, "-": 1, "*": 2, "/": 2}
-  return precedence[op1] >= precedence[op2]
+func absUint(n int) uint {
+  if n < 0 {
+    return uint(-(n + 1)) + 1
+  }
+  return uint(n)
 }
 
-/* Convert the input string into an array of tokens.
- * Tokens can be one of three things:
- * - An integer
- * - An operator: + - / * ( )
- * - Dice notation (XdY): ex. 4d12, 3d20
+func (n BinaryNode) eval(e *evaluator) (int, error) {
+  beforeLeft := slices.Clone(e.labels)
+  left, err := n.Left.eval(e)
+  if err != nil {
+    return 0, err
+  }
+  beforeRight := slices.Clone(e.labels)
+  right, err := n.Right.eval(e)
+  if err != nil {
+    return 0, err
+  }
+
+  result, err := applyOperator(n.Op, left, right, e.rounding, n.Pos)
+  if err != nil {
+    return 0, err
+  }
+  if err := e.combineLabels(n, beforeLeft, beforeRight, left, right); err != nil {
+    return 0, err
+  }
+  return result, nil
+}
+
+/* Applies an arithmetic operator to two values, rounding any division
+ * with the given mode. Pos is the index of the operator, used to report
+ * division by zero or overflow.
  */
-func tokenize(input string) []string {
-  integerPattern := `\d+`
-  operatorPattern := `[+\-*/()]`
-  dicePattern := `\d*d\d+[!?]?`
-  tokenPattern := fmt.Sprintf("(%s)|(%s)|(%s)", dicePattern, integerPattern, operatorPattern)
-  
-  re := regexp.MustCompile(tokenPattern)
-  matches := re.FindAllString(input, -1)
+func applyOperator(op string, left int, right int, rounding string, pos int) (int, error) {
+  var result int
+  overflowed := false
+  switch op {
+  case "+":
+    result = left + right
+    overflowed = (right > 0 && result < left) || (right < 0 && result > left)
+  case "-":
+    result = left - right
+    overflowed = (right < 0 && result < left) || (right > 0 && result > left)
+  case "*":
+    result = left * right
+    overflowed = left != 0 && (result / left != right || (left == -1 && right == math.MinInt))
+  default:
+    if right == 0 {
+      return 0, &DivisionByZeroError{Column: pos + 1}
+    }
+    overflowed = left == math.MinInt && right == -1
+    if !overflowed {
+      result = divide(left, right, rounding)
+    }
+  }
 
-  return matches
+  if overflowed {
+    return 0, &OverflowError{Column: pos + 1}
+  }
+  return result, nil
 }
 
-/* Parses an array of tokens into a final value.
- * First, use shunting yard to change the array of tokens into reverse polish notation.
- * Then, use a stack procedure to evaluate the reverse polish notation,
- * rolling dice as we go!
+/* Recursive descent parser, turning a list of tokens into a syntax tree.
+ * A whole roll may be repeated, handled by parseRepeated:
+ *   roll       := number "x" expression | "repeat" "(" number "," expression ")" | expression
+ * The grammar of an expression, from lowest to highest precedence, is:
+ *   expression := comparison ("?" expression ":" expression)?
+ *   comparison := sum (("==" | "!=" | "<" | "<=" | ">" | ">=") sum)?
+ *   sum        := term (("+" | "-") term)*
+ *   term       := labelled (("*" | "/") labelled)*
+ *   labelled   := factor label?
+ *   factor     := ("+" | "-") factor | number | dice modifier* | "(" expression ")"
+ *               | function "(" expression ("," expression)* ")"
  */
-func parse(tokens []string) (int, []DiceRoll, error) {
-  // An array to contain the results of dice rolls
-  rollResults := []DiceRoll{}
+type parser struct {
+  input []rune
+  tokens []Token
+  pos int
+}
 
-  // Regex we'll need a little further down
-  diePattern := regexp.MustCompile(`^d\d+[!?]?`)
-  dicePattern := regexp.MustCompile(`\d+d\d+[!?]?`)
-  integerPattern := regexp.MustCompile(`\d+`)
+/* Returns the next token without consuming it.
+ */
+func (p *parser) peek() Token {
+  return p.tokens[p.pos]
+}
 
-  valuePattern := regexp.MustCompile(fmt.Sprintf(
-    "(%s)|(%s)|(%s)", dicePattern, diePattern, integerPattern,
-  ))
-  operatorPattern := regexp.MustCompile(`[+\-*/]`)
-  leftParenPattern := regexp.MustCompile(`\(`)
-  rightParenPattern := regexp.MustCompile(`\)`)
-  
-  // First, do the shunting yard algorithm to get it into reverse polish notation
-  operatorStack := []string{}
-  outputQueue := []string{}
+/* Consumes and returns the next token.
+ * The TokenEOF at the end is never consumed.
+ */
+func (p *parser) next() Token {
+  token := p.tokens[p.pos]
+  if token.Kind != TokenEOF {
+    p.pos++
+  }
+  return token
+}
 
-  for _, token := range tokens {
-    switch {
-    case valuePattern.MatchString(token):
-      outputQueue = append(outputQueue, token)
-    case operatorPattern.MatchString(token):
-      for len(operatorStack) > 0 && hasGreaterPrecedence(operatorStack[len(operatorStack)-1], token) {
-        outputQueue = append(outputQueue, operatorStack[len(operatorStack)-1])
-        operatorStack = operatorStack[:len(operatorStack)-1]
-      }
-      operatorStack = append(operatorStack, token)
-    case leftParenPattern.MatchString(token):
-      operatorStack = append(operatorStack, token)
-    case rightParenPattern.MatchString(token):
-      if len(operatorStack) == 0 {
-        return 0, rollResults, errors.New("Unable to parse: mismatched parens")
-      }
-      
-      for operatorStack[len(operatorStack)-1] != "(" {
-        outputQueue = append(outputQueue, operatorStack[len(operatorStack)-1])
-        operatorStack = operatorStack[:len(operatorStack)-1]
-      }
+/* Builds an error describing the token that could not be parsed.
+ */
+func (p *parser) unexpected(token Token, expected string) error {
+  if token.Kind == TokenEOF {
+    return parseErrorAt(token.Pos, "expected %s but reached the end of the expression", expected)
+  }
+  return parseErrorAt(token.Pos, "expected %s but found '%s'", expected, token.Text)
+}
+
+func (p *parser) parseExpression() (Node, error) {
+  condition, err := p.parseComparison()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek().Kind != TokenQuestion {
+    return condition, nil
+  }
+  question := p.next()
+
+  then, err := p.parseExpression()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek().Kind != TokenColon {
+    return nil, p.unexpected(p.peek(), "':'")
+  }
+  p.next()
+
+  otherwise, err := p.parseExpression()
+  if err != nil {
+    return nil, err
+  }
+
+  if hasLabel(condition) {
+    return nil, parseErrorAt(question.Pos, "labels can't be used in the condition before '?'")
+  }
+  // 2d6>=10 counts the dice that rolled 10 or more, which is almost
+  // never what was meant before a ?
+  if dice, ok := condition.(DiceNode); ok && dice.spec.successOn != nil {
+    return nil, parseErrorAt(dice.targetPos, "'%s' straight after dice counts successes, put a space before it to compare the total", dice.spec.successOn.op)
+  }
+  return ConditionalNode{Condition: condition, Then: then, Else: otherwise}, nil
+}
+
+func (p *parser) parseComparison() (Node, error) {
+  left, err := p.parseSum()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek().Kind != TokenComparison {
+    return left, nil
+  }
+
+  op := p.next()
+  right, err := p.parseSum()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek().Kind == TokenComparison {
+    return nil, parseErrorAt(p.peek().Pos, "comparisons can't be chained, use ( ) to group them")
+  }
+
+  if hasLabel(left) || hasLabel(right) {
+    return nil, parseErrorAt(op.Pos, "labels can't be used in a comparison")
+  }
+  return ComparisonNode{Op: op.Text, Left: left, Right: right}, nil
+}
+
+func (p *parser) parseSum() (Node, error) {
+  left, err := p.parseTerm()
+  if err != nil {
+    return nil, err
+  }
+
+  for p.peek().Kind == TokenOperator && (p.peek().Text == "+" || p.peek().Text == "-") {
+    op := p.next()
+    right, err := p.parseTerm()
+    if err != nil {
+      return nil, err
+    }
+    left = BinaryNode{Op: op.Text, Left: left, Right: right, Pos: op.Pos}
+  }
+
+  return left, nil
+}
+
+func (p *parser) parseTerm() (Node, error) {
+  left, err := p.parseLabelled()
+  if err != nil {
+    return nil, err
+  }
+
+  for p.peek().Kind == TokenOperator && (p.peek().Text == "*" || p.peek().Text == "/") {
+    op := p.next()
+    right, err := p.parseLabelled()
+    if err != nil {
+      return nil, err
+    }
+    // Each label's subtotal is scaled along with the result, which only
+    // works when one side is a plain number to scale it by
+    if op.Text == "*" && hasLabel(left) && hasLabel(right) {
+      return nil, parseErrorAt(op.Pos, "labels can only be on one side of '*'")
+    }
+    if op.Text == "/" && hasLabel(right) {
+      return nil, parseErrorAt(op.Pos, "labels can't be used in what's divided by")
+    }
+    left = BinaryNode{Op: op.Text, Left: left, Right: right, Pos: op.Pos}
+  }
+
+  return left, nil
+}
 
-      if len(operatorStack) == 0 {
-        return 0, rollResults, errors.New("Unable to parse: mismatched parens")
+/* Parses a factor along with the label after it, if there is one.
+ * A label at the very end of the expression with a space before it is
+ * left alone, since it labels the whole expression instead.
+ */
+func (p *parser) parseLabelled() (Node, error) {
+  factor, err := p.parseFactor()
+  if err != nil {
+    return nil, err
+  }
+
+  label := p.peek()
+  if label.Kind != TokenLabel || (label.Spaced && p.tokens[p.pos+1].Kind == TokenEOF) {
+    return factor, nil
+  }
+  p.next()
+  return LabelNode{Label: label.Text, Operand: factor}, nil
+}
+
+func (p *parser) parseFactor() (Node, error) {
+  token := p.next()
+
+  switch token.Kind {
+  case TokenNumber:
+    n, err := strconv.Atoi(token.Text)
+    if err != nil {
+      return nil, parseErrorAt(token.Pos, "%s is too large", token.Text)
+    }
+    return NumberNode{Value: n}, nil
+  case TokenDice:
+    return p.parseDice(token)
+  case TokenOperator:
+    if token.Text == "+" || token.Text == "-" {
+      operand, err := p.parseFactor()
+      if err != nil {
+        return nil, err
       }
-      
-      operatorStack = operatorStack[:len(operatorStack)-1]
+      return UnaryNode{Op: token.Text, Operand: operand, Pos: token.Pos}, nil
     }
+  case TokenLeftParen:
+    return p.parseParenthesized()
+  case TokenIdentifier:
+    return p.parseFunction(token)
   }
 
-  if slices.Contains(operatorStack, "(") {
-    return 0, rollResults, errors.New("Unable to parse: mismatched parens")
+  return nil, p.unexpected(token, "a number, dice or '('")
+}
+
+/* Parses the rest of an expression in parentheses, after the (
+ */
+func (p *parser) parseParenthesized() (Node, error) {
+  inner, err := p.parseExpression()
+  if err != nil {
+    return nil, err
   }
+  if p.peek().Kind != TokenRightParen {
+    return nil, p.unexpected(p.peek(), "')'")
+  }
+  p.next()
+  return inner, nil
+}
 
-  for len(operatorStack) > 0 {
-    outputQueue = append(outputQueue, operatorStack[len(operatorStack)-1])
-    operatorStack = operatorStack[:len(operatorStack)-1]
+/* Parses a call to a function, checking it gets the right number of arguments.
+ */
+func (p *parser) parseFunction(name Token) (Node, error) {
+  fn, ok := functions[name.Text]
+  if !ok {
+    return nil, parseErrorAt(name.Pos, "unknown function '%s'", name.Text)
   }
-  
-  // Now, parse the reverse polish notation
-  stack := []int{}
-  for _, token := range outputQueue {
-    switch {
-    case diePattern.MatchString(token):
-      rollResult, rolls := rollDice("1" + token)
-      rollResults = append(rollResults, DiceRoll{
-        Expression: token,
-        Results: rolls,
-      })
-      stack = append(stack, rollResult)
-    case dicePattern.MatchString(token):
-      rollResult, rolls := rollDice(token)
-      rollResults = append(rollResults, DiceRoll{
-        Expression: token,
-        Results: rolls,
-      })
-      stack = append(stack, rollResult)
-    case integerPattern.MatchString(token):
-      n, _ := strconv.Atoi(token)
-      stack = append(stack, n)
-    case operatorPattern.MatchString(token):
-      if len(stack) == 0 {
-        return 0, rollResults, errors.New("Unable to parse: too many operators")
+  if p.peek().Kind != TokenLeftParen {
+    return nil, p.unexpected(p.peek(), "'(' after "+name.Text)
+  }
+  p.next()
+
+  args := []Node{}
+  for {
+    arg, err := p.parseExpression()
+    if err != nil {
+      return nil, err
+    }
+    args = append(args, arg)
+
+    if p.peek().Kind != TokenComma {
+      break
+    }
+    p.next()
+  }
+  if p.peek().Kind != TokenRightParen {
+    return nil, p.unexpected(p.peek(), "',' or ')'")
+  }
+  p.next()
+
+  if len(args) < fn.minArgs || (fn.maxArgs != -1 && len(args) > fn.maxArgs) {
+    expected := fmt.Sprintf("%d", fn.minArgs)
+    if fn.maxArgs == -1 {
+      expected = fmt.Sprintf("at least %d", fn.minArgs)
+    }
+    return nil, parseErrorAt(name.Pos, "%s takes %s argument(s) but was given %d", name.Text, expected, len(args))
+  }
+  // Only rounding functions, which return their argument unchanged,
+  // and functions that scale labels keep each label's subtotal right
+  if fn.rounding == "" && !fn.scalesLabels && slices.ContainsFunc(args, hasLabel) {
+    return nil, parseErrorAt(name.Pos, "labels can't be used inside %s()", name.Text)
+  }
+
+  return FunctionNode{Name: name.Text, Args: args, Pos: name.Pos}, nil
+}
+
+/* Parses dice notation along with any modifiers written after it.
+ */
+func (p *parser) parseDice(token Token) (Node, error) {
+  count, sides, _ := strings.Cut(token.Text, "d")
+
+  spec := diceSpec{count: 1}
+  var err error
+  if count != "" {
+    spec.count, err = strconv.Atoi(count)
+  }
+  if err == nil && strings.EqualFold(sides, "F") {
+    spec.sides = 3
+    spec.fate = true
+  } else if err == nil && strings.HasPrefix(sides, "{") {
+    spec.custom = strings.ToLower(strings.TrimSpace(strings.Trim(sides, "{}")))
+    if spec.custom == "" {
+      return nil, parseErrorAt(token.Pos, "custom dice need a name, ex. d{avalanche}")
+    }
+  } else if err == nil {
+    spec.sides, err = strconv.Atoi(sides)
+  }
+  if err != nil {
+    return nil, parseErrorAt(token.Pos, "%s is too large", token.Text)
+  }
+
+  end := token.Pos + len([]rune(token.Text))
+  targetPos := 0
+  for {
+    next := p.peek()
+    if next.Kind != TokenModifier && (next.Kind != TokenComparison || next.Spaced) {
+      break
+    }
+
+    modifier, err := p.parseModifier(spec.sides)
+    if err != nil {
+      return nil, err
+    }
+    if err := modifier.apply(&spec); err != nil {
+      return nil, parseErrorAt(next.Pos, "%s", err)
+    }
+    if _, ok := modifier.(SuccessModifier); ok {
+      targetPos = next.Pos
+    }
+    end = p.tokens[p.pos-1].Pos + len([]rune(p.tokens[p.pos-1].Text))
+  }
+
+  if err := spec.validate(); err != nil {
+    return nil, parseErrorAt(token.Pos, "%s", err)
+  }
+
+  return DiceNode{
+    Expression: string(p.input[token.Pos:end]),
+    spec: spec,
+    targetPos: targetPos,
+  }, nil
+}
+
+/* Parses a single dice modifier, along with its count or target.
+ */
+func (p *parser) parseModifier(sides int) (DiceModifier, error) {
+  token := p.next()
+
+  switch token.Text {
+  case "!":
+    return KeepModifier{Mode: "kh", Count: 1}, nil
+  case "?":
+    return KeepModifier{Mode: "kl", Count: 1}, nil
+  case "kh", "kl", "dh", "dl":
+    keep := KeepModifier{Mode: token.Text, Count: 1}
+    if p.peek().Kind == TokenNumber && !p.peek().Spaced {
+      n, err := p.parseTarget()
+      if err != nil {
+        return nil, err
       }
-      
-      num2 := stack[len(stack)-1]
-      stack = stack[:len(stack)-1]
-      
-      if len(stack) == 0 {
-        return 0, rollResults, errors.New("Unable to parse: too many operators")
+      keep.Count = n
+    }
+    return keep, nil
+  case "!!", "!!c", "!!p", "e", "ec", "ep":
+    explode := ExplodeModifier{Mode: "explode", On: comparison{op: ">=", target: sides}}
+    if strings.HasSuffix(token.Text, "c") {
+      explode.Mode = "compound"
+    } else if strings.HasSuffix(token.Text, "p") {
+      explode.Mode = "penetrate"
+    }
+    if p.peek().Kind == TokenComparison && !p.peek().Spaced {
+      on, err := p.parseModifierComparison()
+      if err != nil {
+        return nil, err
       }
+      explode.On = on
+    }
+    return explode, nil
+  case "r", "ro":
+    on, err := p.parseModifierComparison()
+    if err != nil {
+      return nil, err
+    }
+    return RerollModifier{Once: token.Text == "ro", On: on}, nil
+  case "f":
+    on, err := p.parseModifierComparison()
+    if err != nil {
+      return nil, err
+    }
+    return FailureModifier{On: on}, nil
+  }
 
-      num1 := stack[len(stack)-1]
-      stack = stack[:len(stack)-1]
-
-      switch token {
-      case "+":
-        stack = append(stack, num1 + num2)
-      case "-":
-        stack = append(stack, num1 - num2)
-      case "*":
-        stack = append(stack, num1 * num2)
-      case "/":
-        stack = append(stack, num1 / num2)
-      }
+  // Anything else is a comparison, which sets the success target
+  p.pos--
+  on, err := p.parseModifierComparison()
+  if err != nil {
+    return nil, err
+  }
+  return SuccessModifier{On: on}, nil
+}
+
+/* Parses a target number for a dice modifier, optionally preceded
+ * by a comparison operator. With no operator, the comparison is "=".
+ */
+func (p *parser) parseModifierComparison() (comparison, error) {
+  c := comparison{op: "="}
+  if p.peek().Kind == TokenComparison && !p.peek().Spaced {
+    c.op = p.next().Text
+  }
+
+  target, err := p.parseTarget()
+  if err != nil {
+    return c, err
+  }
+  c.target = target
+  return c, nil
+}
+
+/* Parses the number written directly after a dice modifier.
+ */
+func (p *parser) parseTarget() (int, error) {
+  token := p.peek()
+  if token.Kind != TokenNumber || token.Spaced {
+    return 0, p.unexpected(token, "a number")
+  }
+  p.next()
+
+  n, err := strconv.Atoi(token.Text)
+  if err != nil {
+    return 0, parseErrorAt(token.Pos, "%s is too large", token.Text)
+  }
+  return n, nil
+}
+
+/* Parses the input into a syntax tree without evaluating it.
+ */
+func parse(input string) (Node, error) {
+  count, tree, err := parseRepeated(input)
+  if err != nil {
+    return nil, err
+  }
+  if count > 0 {
+    return nil, parseErrorAt(0, "a repeated roll can't be used here")
+  }
+  return tree, nil
+}
+
+/* Parses an expression that may be repeated, written either as
+ * Nx <expression> or repeat(N, <expression>). The count is 0 if the
+ * expression isn't repeated.
+ */
+func parseRepeated(input string) (int, Node, error) {
+  tokens, err := lex(input)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  p := &parser{input: []rune(input), tokens: tokens}
+  count := 0
+  wrapped := false
+  if len(tokens) > 2 && tokens[0].Kind == TokenNumber && tokens[1].Kind == TokenIdentifier && tokens[1].Text == "x" {
+    count, err = parseRepeatCount(p.next())
+    p.next()
+  } else if len(tokens) > 2 && tokens[0].Kind == TokenIdentifier && tokens[0].Text == "repeat" && tokens[1].Kind == TokenLeftParen {
+    p.next()
+    p.next()
+    if p.peek().Kind != TokenNumber {
+      return 0, nil, p.unexpected(p.peek(), "how many times to repeat")
+    }
+    count, err = parseRepeatCount(p.next())
+    if err == nil && p.peek().Kind != TokenComma {
+      err = p.unexpected(p.peek(), "','")
+    }
+    p.next()
+    wrapped = true
+  }
+  if err != nil {
+    return 0, nil, err
+  }
+
+  tree, err := p.parseExpression()
+  if err != nil {
+    return 0, nil, err
+  }
+
+  // A label at the end, after a space, labels the whole expression
+  if p.peek().Kind == TokenLabel {
+    tree = LabelNode{Label: p.next().Text, Operand: tree}
+  }
+
+  if wrapped {
+    if p.peek().Kind != TokenRightParen {
+      return 0, nil, p.unexpected(p.peek(), "')'")
+    }
+    p.next()
+    if p.peek().Kind != TokenEOF {
+      return 0, nil, parseErrorAt(p.peek().Pos, "nothing can come after a repeated roll")
     }
   }
+  if p.peek().Kind == TokenRightParen {
+    return 0, nil, parseErrorAt(p.peek().Pos, "found ')' without a matching '('")
+  }
+  if p.peek().Kind != TokenEOF {
+    return 0, nil, p.unexpected(p.peek(), "an operator")
+  }
+
+  return count, tree, nil
+}
 
-  if len(stack) != 1 {
-    return 0, rollResults, errors.New("Unable to parse malformed input")
+/* Reads how many times a roll should be repeated.
+ */
+func parseRepeatCount(token Token) (int, error) {
+  count, err := strconv.Atoi(token.Text)
+  if err != nil || count < 1 || count > maxRepeats {
+    return 0, parseErrorAt(token.Pos, "a roll can only be repeated 1 to %d times", maxRepeats)
   }
-  
-  return stack[0], rollResults, nil
+  return count, nil
 }
 
-/* Parses the given expression.
+/* Parses the given expression and rolls any dice in it.
  * Expression must contain only integers and dice notation,
  * and may only use the operators + - * / and ()
+ * + and - may also be written in front of any number, dice or ().
+ * Comparisons evaluate to 1 or 0, and can pick between two expressions
+ * with a conditional, ex. d20+5 >= 15 ? 2d6+3 : 0
+ * Functions from the functions registry may be called, ex. max(1, d4-2).
+ * floor(), ceil(), round() and truncate() change how the divisions
+ * inside them are rounded.
  */
 func ParseExpression(input string) (int, []DiceRoll, error) {
-  // Tokenize
-  tokenized := tokenize(input)
-  
-  // Quick validation: Only valid tokens in input string
-  inputToCompare := strings.ReplaceAll(input, " ", "")
-  inputFromTokenized := strings.Join(tokenized, "")
-  if inputToCompare != inputFromTokenized {
-    return 0, []DiceRoll{}, errors.New(fmt.Sprintf("Invalid tokens found %v %v", inputToCompare, inputFromTokenized))
+  return ParseExpressionWithOptions(input, EvalOptions{})
+}
+
+/* Parses the given expression and rolls any dice in it,
+ * evaluating it with the given options.
+ */
+func ParseExpressionWithOptions(input string, options EvalOptions) (int, []DiceRoll, error) {
+  tree, err := parse(input)
+  if err != nil {
+    return 0, []DiceRoll{}, err
+  }
+
+  e := newEvaluator(options)
+  result, err := tree.eval(e)
+  if err != nil {
+    return 0, e.rolls, err
+  }
+
+  return result, e.rolls, nil
+}
+
+/* Sets up an evaluator with the given options, filling in the defaults.
+ */
+func newEvaluator(options EvalOptions) *evaluator {
+  e := &evaluator{rolls: []DiceRoll{}, rounding: options.Rounding, roller: options.Roller, critical: options.Critical, customDice: options.CustomDice}
+  if e.rounding == "" {
+    e.rounding = "floor"
+  }
+  if e.roller == nil {
+    e.roller = defaultRoller
   }
+  return e
+}
+
+/* Evaluates a parsed expression once, rolling its dice.
+ */
+func rollTree(tree Node, options EvalOptions) (RollRow, error) {
+  e := newEvaluator(options)
+  result, err := tree.eval(e)
+  if err != nil {
+    return RollRow{}, err
+  }
+  return RollRow{Result: result, Rolls: e.rolls, Labels: e.labels}, nil
+}
+
+// The most times a single roll may be repeated
+const maxRepeats = 20
+
+/* The subtotal of everything with one label in a roll,
+ * ex. fire in 2d6[slashing] + 3d6[fire] + 4
+ */
+type LabelTotal struct {
+  Label string
+  Total int
+}
+
+/* The result of one repeat of a repeated roll.
+ * Labels holds the subtotal of each label used in the roll.
+ */
+type RollRow struct {
+  Result int
+  Rolls []DiceRoll
+  Labels []LabelTotal
+}
+
+/* Parses the given expression and rolls it, once for every time it is
+ * repeated, ex. 6x 4d6kh3 or repeat(6, 4d6kh3). Each repeat rolls its
+ * dice independently. An expression that isn't repeated gives one row.
+ */
+func ParseRepeatedExpression(input string, options EvalOptions) ([]RollRow, error) {
+  count, tree, err := parseRepeated(input)
+  if err != nil {
+    return nil, err
+  }
+
+  rows := []RollRow{}
+  for i := 0; i < max(count, 1); i++ {
+    row, err := rollTree(tree, options)
+    if err != nil {
+      return nil, err
+    }
+    rows = append(rows, row)
+  }
+  return rows, nil
+}
+
+/* Sorts the rows of a repeated roll by their results.
+ */
+func SortRows(rows []RollRow, descending bool) {
+  slices.SortStableFunc(rows, func(a RollRow, b RollRow) int {
+    if descending {
+      return cmp.Compare(b.Result, a.Result)
+    }
+    return cmp.Compare(a.Result, b.Result)
+  })
+}
 
-  // Parse
-  return parse(tokenized)
+/* One of several rolls written together, separated by semicolons.
+ * Label is the text before a colon at the start of the roll, if any,
+ * ex. Attack in "Attack: d20+7; Damage: 2d6+4".
+ */
+type LabelledRoll struct {
+  Label string
+  Expression string
+}
+
+// The most rolls that may be made at once, separated by semicolons
+const maxRollSections = 10
+
+// Matches a label at the start of a roll, ex. "Attack:"
+var rollLabel = regexp.MustCompile(`^\s*([A-Za-z][\w '-]*?)\s*:`)
+
+/* Splits the input into separate rolls at each semicolon, taking off
+ * the label at the start of each roll. Empty rolls are skipped.
+ */
+func SplitRolls(input string) []LabelledRoll {
+  rolls := []LabelledRoll{}
+  for _, part := range strings.Split(input, ";") {
+    roll := LabelledRoll{Expression: strings.TrimSpace(part)}
+    if match := rollLabel.FindStringSubmatch(part); match != nil {
+      roll.Label = match[1]
+      roll.Expression = strings.TrimSpace(part[len(match[0]):])
+    }
+    if roll.Expression != "" || roll.Label != "" {
+      rolls = append(rolls, roll)
+    }
+  }
+  return rolls
 }
 
 /* Given a macro and a list of values, substitutes them into
  * the macro to produce an expression with the values filled in. 
+ * The label at the start of each roll is left as it is.
  */
 func FillMacro(input string, variables[]string) string {
-  // Set up variables for macros (A, B, C, etc..)
-  variablesMap := make(map[string]string)
-  for i, v := range variables {
-    if i < 26 {
-      key := string(rune('A' + i))
-      variablesMap[key] = v
-    }
+  parts := strings.Split(input, ";")
+  for i, part := range parts {
+    label := rollLabel.FindString(part)
+    parts[i] = label + fillInputs(part[len(label):], variables)
   }
+  return strings.Join(parts, ";")
+}
 
-  // Replace variables in the input string
-  for key, value := range variablesMap {
-    input = strings.Replace(input, key, value, -1)
-  }
+/* Substitutes the values into one roll of a macro.
+ * An F straight after a d is a Fate die (dF), not an input, and
+ * nothing inside a [label] or a custom die's d{name} is replaced.
+ */
+func fillInputs(input string, variables[]string) string {
+  runes := []rune(input)
+  var filled strings.Builder
+  closing := rune(0)
+  for i, r := range runes {
+    switch {
+    case closing != 0:
+      if r == closing {
+        closing = 0
+      }
+    case r == '[':
+      closing = ']'
+    case r == '{':
+      closing = '}'
+    }
 
-  return input
+    // Variables for macros are A, B, C, etc..
+    index := int(r - 'A')
+    isFate := r == 'F' && i > 0 && runes[i-1] == 'd'
+    if r >= 'A' && r <= 'Z' && index < len(variables) && !isFate && closing == 0 {
+      filled.WriteString(variables[index])
+    } else {
+      filled.WriteRune(r)
+    }
+  }
+  return filled.String()
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+  "errors"
+  "maps"
+  "slices"
+  "strings"
   "testing"
 )
 
@@ -274,6 +278,692 @@ func TestParseShorthand_2(t *testing.T) {
   }
 }
 
+/* Test that keep/drop modifiers keep the right dice */
+func TestRollKeepDrop(t *testing.T) {
+  cases := []struct {
+    expression string
+    kept int
+    highest bool
+  }{
+    {"4d6kh3", 3, true},
+    {"4d6dl1", 3, true},
+    {"2d20kl1", 1, false},
+    {"5d8dh2", 3, false},
+    {"3d10kh", 1, true},
+  }
+
+  for _, c := range cases {
+    for i := 0; i < 10; i++ {
+      result, rolls, error := ParseExpression(c.expression)
+      if error != nil {
+        t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+      }
+
+      if len(rolls) != 1 || rolls[0].Expression != c.expression {
+        t.Fatalf("Roll %s: %s not found in the results", c.expression, c.expression)
+      }
+
+      if len(rolls[0].Flags) != len(rolls[0].Results) {
+        t.Fatalf("Roll %s: got %d flags for %d dice", c.expression, len(rolls[0].Flags), len(rolls[0].Results))
+      }
+
+      sum := 0
+      kept := []int{}
+      dropped := []int{}
+      for j, v := range rolls[0].Results {
+        if rolls[0].Flags[j] & DieDropped != 0 {
+          dropped = append(dropped, v)
+        } else {
+          kept = append(kept, v)
+          sum += v
+        }
+      }
+
+      if len(kept) != c.kept {
+        t.Fatalf("Roll %s: kept %d dice instead of %d", c.expression, len(kept), c.kept)
+      }
+
+      if sum != result {
+        t.Fatalf("Roll %s: Result %d was not the sum of the kept dice (%d)", c.expression, result, sum)
+      }
+
+      for _, k := range kept {
+        for _, d := range dropped {
+          if (c.highest && d > k) || (!c.highest && d < k) {
+            t.Fatalf("Roll %s: dropped %d but kept %d", c.expression, d, k)
+          }
+        }
+      }
+    }
+  }
+}
+
+/* Test that advantage marks the lower die as dropped */
+func TestRollAdvantageFlags(t *testing.T) {
+  _, rolls, error := ParseExpression("2d20!")
+  if error != nil {
+    t.Fatalf("Parsing 2d20! failed with error: %s", error)
+  }
+
+  droppedCount := 0
+  for _, f := range rolls[0].Flags {
+    if f & DieDropped != 0 {
+      droppedCount++
+    }
+  }
+  if droppedCount != 1 {
+    t.Fatalf("Roll 2d20!: %d dice were dropped instead of 1", droppedCount)
+  }
+}
+
+/* Test that exploding dice roll an extra die for every maximum roll */
+func TestRollExploding(t *testing.T) {
+  for _, expression := range []string{"3d6!!", "3d6e", "d10e>=9", "4d4!!c", "4d4!!p"} {
+    for i := 0; i < 20; i++ {
+      result, rolls, error := ParseExpression(expression)
+      if error != nil {
+        t.Fatalf("Parsing %s failed with error: %s", expression, error)
+      }
+
+      if len(rolls) != 1 || rolls[0].Expression != expression {
+        t.Fatalf("Roll %s: %s not found in the results", expression, expression)
+      }
+
+      roll := rolls[0]
+      sum := 0
+      for j, v := range roll.Results {
+        sum += v
+        exploded := roll.Flags[j] & DieExploded != 0
+        if exploded && j == len(roll.Results)-1 {
+          t.Fatalf("Roll %s: last die exploded but no extra die was rolled", expression)
+        }
+        if exploded && roll.Flags[j+1] & DieCompounded == 0 && expression == "4d4!!c" {
+          t.Fatalf("Roll %s: extra die was not compounded", expression)
+        }
+      }
+
+      if sum != result {
+        t.Fatalf("Roll %s: Result %d was not the sum of the rolls (%d)", expression, result, sum)
+      }
+    }
+  }
+}
+
+/* Test that exploding on every face is rejected rather than looping */
+func TestRollExplodingEveryFace(t *testing.T) {
+  _, _, error := ParseExpression("d6e>=1")
+  if error == nil {
+    t.Fatalf("Parsing d6e>=1 should have failed")
+  }
+
+  _, rolls, error := ParseExpression("d2e>=2")
+  if error != nil {
+    t.Fatalf("Parsing d2e>=2 failed with error: %s", error)
+  }
+  if len(rolls[0].Results) > maxExplosions + 1 {
+    t.Fatalf("Roll d2e>=2: exploded past the limit (%d dice)", len(rolls[0].Results))
+  }
+}
+
+/* Test that keep/drop treats a compounded die as a single die */
+func TestRollCompoundingKeep(t *testing.T) {
+  for i := 0; i < 20; i++ {
+    result, rolls, error := ParseExpression("3d4!!ckh1")
+    if error != nil {
+      t.Fatalf("Parsing 3d4!!ckh1 failed with error: %s", error)
+    }
+
+    roll := rolls[0]
+    kept := 0
+    sum := 0
+    for j, v := range roll.Results {
+      if roll.Flags[j] & DieDropped == 0 {
+        sum += v
+        if roll.Flags[j] & DieCompounded == 0 {
+          kept++
+        }
+      }
+    }
+    if kept != 1 {
+      t.Fatalf("Roll 3d4!!ckh1: kept %d dice instead of 1", kept)
+    }
+    if sum != result {
+      t.Fatalf("Roll 3d4!!ckh1: Result %d was not the sum of the kept dice (%d)", result, sum)
+    }
+  }
+}
+
+/* Test that rerolled dice are kept in the results but not counted */
+func TestRollReroll(t *testing.T) {
+  for _, expression := range []string{"2d6ro1", "4d6r<2", "3d4ro<=2", "2d6r1!!"} {
+    for i := 0; i < 20; i++ {
+      result, rolls, error := ParseExpression(expression)
+      if error != nil {
+        t.Fatalf("Parsing %s failed with error: %s", expression, error)
+      }
+
+      roll := rolls[0]
+      sum := 0
+      for j, v := range roll.Results {
+        if roll.Flags[j] & DieRerolled != 0 {
+          if j == len(roll.Results)-1 {
+            t.Fatalf("Roll %s: last die was rerolled but not replaced", expression)
+          }
+          continue
+        }
+        sum += v
+        if expression == "4d6r<2" && v < 2 {
+          t.Fatalf("Roll %s: kept a %d which should have been rerolled", expression, v)
+        }
+      }
+
+      if sum != result {
+        t.Fatalf("Roll %s: Result %d was not the sum of the rolls (%d)", expression, result, sum)
+      }
+    }
+  }
+}
+
+/* Test that rerolling once never rerolls the same die twice */
+func TestRollRerollOnce(t *testing.T) {
+  for i := 0; i < 50; i++ {
+    _, rolls, error := ParseExpression("3d2ro1")
+    if error != nil {
+      t.Fatalf("Parsing 3d2ro1 failed with error: %s", error)
+    }
+
+    roll := rolls[0]
+    for j := 1; j < len(roll.Flags); j++ {
+      if roll.Flags[j] & DieRerolled != 0 && roll.Flags[j-1] & DieRerolled != 0 {
+        t.Fatalf("Roll 3d2ro1: rerolled the same die twice %v", roll.Results)
+      }
+    }
+  }
+}
+
+/* Test that rerolling on every face is rejected */
+func TestRollRerollEveryFace(t *testing.T) {
+  _, _, error := ParseExpression("d6r<7")
+  if error == nil {
+    t.Fatalf("Parsing d6r<7 should have failed")
+  }
+}
+
+/* Test that success counting counts dice instead of summing them */
+func TestRollSuccesses(t *testing.T) {
+  cases := []struct {
+    expression string
+    success func(int) bool
+    failure func(int) bool
+  }{
+    {"8d10>=8", func(v int) bool { return v >= 8 }, nil},
+    {"10d6>4f1", func(v int) bool { return v > 4 }, func(v int) bool { return v == 1 }},
+    {"5d6=6f<3", func(v int) bool { return v == 6 }, func(v int) bool { return v < 3 }},
+  }
+
+  for _, c := range cases {
+    for i := 0; i < 10; i++ {
+      result, rolls, error := ParseExpression(c.expression)
+      if error != nil {
+        t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+      }
+
+      roll := rolls[0]
+      expected := 0
+      for j, v := range roll.Results {
+        isSuccess := roll.Flags[j] & DieSuccess != 0
+        isFailure := roll.Flags[j] & DieFailure != 0
+        if isSuccess != c.success(v) {
+          t.Fatalf("Roll %s: die %d marked as success: %v", c.expression, v, isSuccess)
+        }
+        if c.failure != nil && isFailure != c.failure(v) {
+          t.Fatalf("Roll %s: die %d marked as failure: %v", c.expression, v, isFailure)
+        }
+        if isSuccess {
+          expected++
+        }
+        if isFailure {
+          expected--
+        }
+      }
+
+      if result != expected {
+        t.Fatalf("Roll %s: Result was %d instead of %d", c.expression, result, expected)
+      }
+    }
+  }
+}
+
+/* Test that counting failures needs a success target */
+func TestRollFailuresWithoutSuccesses(t *testing.T) {
+  _, _, error := ParseExpression("10d6f1")
+  if error == nil {
+    t.Fatalf("Parsing 10d6f1 should have failed")
+  }
+}
+
+/* Test that invalid expressions report the column that failed */
+func TestParseErrorColumn(t *testing.T) {
+  cases := []struct {
+    expression string
+    column int
+  }{
+    {"2d6 + x", 7},
+    {"(1 + 2", 7},
+    {"1 + 2)", 6},
+    {"4d6kq", 4},
+    {"d20 +", 6},
+    {"2d6 3", 5},
+    {"4d6r", 5},
+    {"30d6", 1},
+    {"d6e>=1", 3},
+    {"2d6>=10 ? 1 : 0", 4},
+    {"١+1", 1},
+  }
+
+  for _, c := range cases {
+    _, _, err := ParseExpression(c.expression)
+    if err == nil {
+      t.Fatalf("Parsing %s should have failed", c.expression)
+    }
+
+    parseError, ok := err.(*ParseError)
+    if !ok {
+      t.Fatalf("Parsing %s gave %T instead of a ParseError", c.expression, err)
+    }
+    if parseError.Column != c.column {
+      t.Fatalf("Parsing %s failed at column %d instead of %d: %s", c.expression, parseError.Column, c.column, err)
+    }
+  }
+}
+
+/* Test that whitespace is allowed anywhere between tokens */
+func TestParseWhitespace(t *testing.T) {
+  result, _, error := ParseExpression(" ( 1+ 2 ) *3 ")
+  if error != nil {
+    t.Fatalf("Parsing ( 1+ 2 ) *3 failed with error: %s", error)
+  }
+  if result != 9 {
+    t.Fatalf("Parse ( 1+ 2 ) *3: got %d instead of 9", result)
+  }
+}
+
+/* Test that unary minus and plus work anywhere an operand can go */
+func TestParseUnary(t *testing.T) {
+  cases := []struct {
+    expression string
+    expected int
+  }{
+    {"-1", -1},
+    {"-1+2", 1},
+    {"5 + -2", 3},
+    {"3 * -(1 + 1)", -6},
+    {"--4", 4},
+    {"+3 - +1", 2},
+    {"-2 * 3", -6},
+    {"10 - -(2*2)", 14},
+  }
+
+  for _, c := range cases {
+    result, _, error := ParseExpression(c.expression)
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+    }
+    if result != c.expected {
+      t.Fatalf("Parse %s: got %d instead of %d", c.expression, result, c.expected)
+    }
+  }
+}
+
+/* Test that unary minus can be applied to dice */
+func TestParseUnaryDice(t *testing.T) {
+  for _, expression := range []string{"-1+d20", "d20 + -2", "-d20"} {
+    result, rolls, error := ParseExpression(expression)
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", expression, error)
+    }
+    if len(rolls) != 1 || rolls[0].Expression != "d20" {
+      t.Fatalf("Roll %s: d20 not found in the results", expression)
+    }
+
+    roll := rolls[0].Results[0]
+    expected := map[string]int{"-1+d20": roll - 1, "d20 + -2": roll - 2, "-d20": -roll}[expression]
+    if result != expected {
+      t.Fatalf("Roll %s: Result was %d instead of %d", expression, result, expected)
+    }
+  }
+}
+
+/* Test that a macro can be filled with negative inputs */
+func TestFillMacroNegative(t *testing.T) {
+  expression := FillMacro("A + B", []string{"10", "-2"})
+  result, _, error := ParseExpression(expression)
+  if error != nil {
+    t.Fatalf("Parsing %s failed with error: %s", expression, error)
+  }
+  if result != 8 {
+    t.Fatalf("Parse %s: got %d instead of 8", expression, result)
+  }
+}
+
+/* Test that dividing by zero gives an error instead of panicking */
+func TestParseDivisionByZero(t *testing.T) {
+  for _, expression := range []string{"1/0", "d20 / (2 - 2)", "5 / (d6 - d6) * 0 + 4/0"} {
+    _, _, err := ParseExpression(expression)
+
+    var divisionError *DivisionByZeroError
+    if !errors.As(err, &divisionError) {
+      t.Fatalf("Parsing %s gave %v instead of a division by zero error", expression, err)
+    }
+  }
+
+  _, _, err := ParseExpression("1/0")
+  if err.(*DivisionByZeroError).Column != 2 {
+    t.Fatalf("Parse 1/0: division by zero reported at column %d instead of 2", err.(*DivisionByZeroError).Column)
+  }
+}
+
+/* Test that results too large to represent give an error instead of wrapping */
+func TestParseOverflow(t *testing.T) {
+  cases := []string{
+    "9223372036854775807 + 1",
+    "-9223372036854775807 - 2",
+    "4611686018427387904 * 2",
+    "3037000500 * 3037000500",
+    "-(-9223372036854775807 - 1)",
+    "(-9223372036854775807 - 1) / -1",
+    "(-9223372036854775807 - 1) * -1",
+  }
+
+  for _, expression := range cases {
+    _, _, err := ParseExpression(expression)
+
+    var overflowError *OverflowError
+    if !errors.As(err, &overflowError) {
+      t.Fatalf("Parsing %s gave %v instead of an overflow error", expression, err)
+    }
+  }
+
+  result, _, err := ParseExpression("9223372036854775806 + 1")
+  if err != nil || result != 9223372036854775807 {
+    t.Fatalf("Parse 9223372036854775806 + 1: got %d, %v", result, err)
+  }
+}
+
+/* Test that floor(), ceil(), round() and truncate() round the divisions inside them */
+func TestParseRounding(t *testing.T) {
+  cases := []struct {
+    expression string
+    expected int
+  }{
+    {"7/2", 3},
+    {"-7/2", -4},
+    {"floor(7/2)", 3},
+    {"floor(-7/2)", -4},
+    {"ceil(7/2)", 4},
+    {"ceil(-7/2)", -3},
+    {"round(7/2)", 4},
+    {"round(-7/2)", -3},
+    {"round(8/3)", 3},
+    {"round(-8/3)", -3},
+    {"round(7/3)", 2},
+    {"truncate(-7/2)", -3},
+    {"ceil(1 + floor(7/2) + 5/2)", 7},
+    {"6/3", 2},
+    {"7/-2", -4},
+  }
+
+  for _, c := range cases {
+    result, _, error := ParseExpression(c.expression)
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+    }
+    if result != c.expected {
+      t.Fatalf("Parse %s: got %d instead of %d", c.expression, result, c.expected)
+    }
+  }
+}
+
+/* Test that the default rounding for plain division can be changed */
+func TestParseDefaultRounding(t *testing.T) {
+  cases := map[string]int{"floor": -4, "ceil": -3, "round": -3, "truncate": -3}
+  for rounding, expected := range cases {
+    result, _, error := ParseExpressionWithOptions("-7/2", EvalOptions{Rounding: rounding})
+    if error != nil {
+      t.Fatalf("Parsing -7/2 with %s rounding failed with error: %s", rounding, error)
+    }
+    if result != expected {
+      t.Fatalf("Parse -7/2 with %s rounding: got %d instead of %d", rounding, result, expected)
+    }
+  }
+
+  result, _, _ := ParseExpressionWithOptions("floor(7/2) + 7/2", EvalOptions{Rounding: "ceil"})
+  if result != 7 {
+    t.Fatalf("Parse floor(7/2) + 7/2 with ceil rounding: got %d instead of 7", result)
+  }
+}
+
+/* Test that unknown functions are rejected */
+func TestParseUnknownFunction(t *testing.T) {
+  _, _, error := ParseExpression("sqrt(4)")
+  if error == nil {
+    t.Fatalf("Parsing sqrt(4) should have failed")
+  }
+}
+
+/* Test the built-in functions */
+func TestParseFunctions(t *testing.T) {
+  cases := []struct {
+    expression string
+    expected int
+  }{
+    {"min(3, 1, 2)", 1},
+    {"max(3, 1, 2)", 3},
+    {"max(1, 0 - 5)", 1},
+    {"min(7)", 7},
+    {"abs(-4)", 4},
+    {"abs(4)", 4},
+    {"clamp(25, 1, 20)", 20},
+    {"clamp(-3, 1, 20)", 1},
+    {"clamp(5, 1, 20)", 5},
+    {"halve(7)", 3},
+    {"halve(-7)", -4},
+    {"double(-4)", -8},
+    {"2 * max(1, min(5, 3)) + 1", 7},
+    {"floor(max(7, 3)/2)", 3},
+    {"ceil(max(7/2, 3))", 4},
+  }
+
+  for _, c := range cases {
+    result, _, error := ParseExpression(c.expression)
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+    }
+    if result != c.expected {
+      t.Fatalf("Parse %s: got %d instead of %d", c.expression, result, c.expected)
+    }
+  }
+}
+
+/* Test that functions can take dice as arguments */
+func TestParseFunctionsWithDice(t *testing.T) {
+  for i := 0; i < 20; i++ {
+    result, rolls, error := ParseExpression("max(1, d4-2)")
+    if error != nil {
+      t.Fatalf("Parsing max(1, d4-2) failed with error: %s", error)
+    }
+    expected := max(1, rolls[0].Results[0] - 2)
+    if result != expected {
+      t.Fatalf("Roll max(1, d4-2): Result was %d instead of %d", result, expected)
+    }
+  }
+}
+
+/* Test that functions given the wrong number of arguments are rejected */
+func TestParseFunctionArguments(t *testing.T) {
+  for _, expression := range []string{"abs(1, 2)", "clamp(1, 2)", "min()", "max(1,", "floor 2"} {
+    _, _, error := ParseExpression(expression)
+    if error == nil {
+      t.Fatalf("Parsing %s should have failed", expression)
+    }
+  }
+}
+
+/* Test comparison operators and conditionals */
+func TestParseComparisons(t *testing.T) {
+  cases := []struct {
+    expression string
+    expected int
+  }{
+    {"3 > 2", 1},
+    {"3 < 2", 0},
+    {"2 >= 2", 1},
+    {"2 <= 1", 0},
+    {"2 == 2", 1},
+    {"2 != 2", 0},
+    {"1 + 2 == 3", 1},
+    {"5 > 3 ? 10 : 20", 10},
+    {"5 < 3 ? 10 : 20", 20},
+    {"1 ? 2 : 0 ? 3 : 4", 2},
+    {"0 ? 2 : 0 ? 3 : 4", 4},
+    {"(2 > 1) + (3 > 1)", 2},
+    {"max(1 > 0 ? 5 : 6, 2)", 5},
+    {"2d6 >= 2 ? 1 : 0", 1},
+  }
+
+  for _, c := range cases {
+    result, _, error := ParseExpression(c.expression)
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+    }
+    if result != c.expected {
+      t.Fatalf("Parse %s: got %d instead of %d", c.expression, result, c.expected)
+    }
+  }
+}
+
+/* Test that only the chosen branch of a conditional rolls its dice */
+func TestParseConditionalRollsOneBranch(t *testing.T) {
+  for i := 0; i < 20; i++ {
+    result, rolls, error := ParseExpression("d20+5 >= 15 ? 2d6+3 : d4")
+    if error != nil {
+      t.Fatalf("Parsing d20+5 >= 15 ? 2d6+3 : d4 failed with error: %s", error)
+    }
+
+    if len(rolls) != 2 || rolls[0].Expression != "d20" {
+      t.Fatalf("Roll d20+5 >= 15 ? 2d6+3 : d4: rolled %d expressions", len(rolls))
+    }
+
+    hit := rolls[0].Results[0] + 5 >= 15
+    if hit && rolls[1].Expression != "2d6" {
+      t.Fatalf("Roll d20+5 >= 15 ? 2d6+3 : d4: hit but rolled %s", rolls[1].Expression)
+    }
+    if !hit && rolls[1].Expression != "d4" {
+      t.Fatalf("Roll d20+5 >= 15 ? 2d6+3 : d4: missed but rolled %s", rolls[1].Expression)
+    }
+
+    expected := rolls[1].Results[0]
+    if hit {
+      expected = rolls[1].Results[0] + rolls[1].Results[1] + 3
+    }
+    if result != expected {
+      t.Fatalf("Roll d20+5 >= 15 ? 2d6+3 : d4: Result was %d instead of %d", result, expected)
+    }
+  }
+}
+
+/* Test that a comparison written directly after dice is a success target,
+ * while one separated by a space compares the total */
+func TestParseComparisonAfterDice(t *testing.T) {
+  for i := 0; i < 20; i++ {
+    result, rolls, error := ParseExpression("3d6 >= 10")
+    if error != nil {
+      t.Fatalf("Parsing 3d6 >= 10 failed with error: %s", error)
+    }
+
+    sum := 0
+    for j, v := range rolls[0].Results {
+      sum += v
+      if rolls[0].Flags[j] & DieSuccess != 0 {
+        t.Fatalf("Roll 3d6 >= 10: counted successes instead of comparing the total")
+      }
+    }
+    if (sum >= 10) != (result == 1) {
+      t.Fatalf("Roll 3d6 >= 10: got %d for a total of %d", result, sum)
+    }
+  }
+}
+
+/* Test that malformed comparisons and conditionals are rejected */
+func TestParseComparisonErrors(t *testing.T) {
+  for _, expression := range []string{"1 < 2 < 3", "1 ? 2", "1 ? : 2", "1 : 2"} {
+    _, _, error := ParseExpression(expression)
+    if error == nil {
+      t.Fatalf("Parsing %s should have failed", expression)
+    }
+  }
+}
+
+/* A Roller that returns the given faces in order, for deterministic tests.
+ * Each face must be between 1 and the number of sides being rolled. */
+type scriptedRoller struct {
+  faces []int
+}
+
+func (r *scriptedRoller) Intn(n int) int {
+  face := r.faces[0]
+  r.faces = r.faces[1:]
+  return face - 1
+}
+
+/* Test dice modifiers against known rolls */
+func TestRollScripted(t *testing.T) {
+  cases := []struct {
+    expression string
+    faces []int
+    expected int
+    results []int
+    flags []DieFlag
+  }{
+    {"4d6kh3", []int{3, 1, 6, 4}, 13, []int{3, 1, 6, 4}, []DieFlag{0, DieDropped, 0, 0}},
+    {"2d20?", []int{15, 4}, 4, []int{15, 4}, []DieFlag{DieDropped, 0}},
+    {"3d6!!", []int{6, 6, 2, 4, 1}, 19, []int{6, 6, 2, 4, 1}, []DieFlag{DieExploded, DieExploded, 0, 0, 0}},
+    {"2d6!!ckh1", []int{6, 3, 5}, 9, []int{6, 3, 5}, []DieFlag{DieExploded, DieCompounded, DieDropped}},
+    {"d6!!p", []int{6, 6, 2}, 12, []int{6, 5, 1}, []DieFlag{DieExploded, DieExploded, 0}},
+    {"2d6ro1", []int{1, 1, 5}, 6, []int{1, 1, 5}, []DieFlag{DieRerolled, 0, 0}},
+    {"2d6r<2", []int{1, 1, 3, 6}, 9, []int{1, 1, 3, 6}, []DieFlag{DieRerolled, DieRerolled, 0, 0}},
+    {"4d10>=8f1", []int{8, 1, 10, 5}, 1, []int{8, 1, 10, 5}, []DieFlag{DieSuccess, DieFailure, DieSuccess, 0}},
+    {"d20 >= 10 ? 2d6 : d4", []int{12, 3, 4}, 7, nil, nil},
+    {"d20 >= 10 ? 2d6 : d4", []int{2, 3}, 3, nil, nil},
+  }
+
+  for _, c := range cases {
+    result, rolls, error := ParseExpressionWithOptions(c.expression, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if error != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, error)
+    }
+    if result != c.expected {
+      t.Fatalf("Roll %s with %v: got %d instead of %d", c.expression, c.faces, result, c.expected)
+    }
+    if c.results != nil && !slices.Equal(rolls[0].Results, c.results) {
+      t.Fatalf("Roll %s with %v: rolled %v instead of %v", c.expression, c.faces, rolls[0].Results, c.results)
+    }
+    if c.flags != nil && !slices.Equal(rolls[0].Flags, c.flags) {
+      t.Fatalf("Roll %s with %v: got flags %v instead of %v", c.expression, c.faces, rolls[0].Flags, c.flags)
+    }
+  }
+}
+
+/* Test that rolling with the same seed gives the same results */
+func TestRollSeeded(t *testing.T) {
+  first, firstRolls, _ := ParseExpressionWithOptions("10d20 + 4d6!!", EvalOptions{Roller: NewRoller(42)})
+  second, secondRolls, _ := ParseExpressionWithOptions("10d20 + 4d6!!", EvalOptions{Roller: NewRoller(42)})
+
+  if first != second || !slices.Equal(firstRolls[0].Results, secondRolls[0].Results) {
+    t.Fatalf("Rolling with the same seed gave %d and %d", first, second)
+  }
+}
+
 /* Test that filling a macro works as expected */
 func TestFillMacro(t *testing.T) {
   macro := "A + (B / 2)"
@@ -283,3 +973,313 @@ func TestFillMacro(t *testing.T) {
     t.Fatalf("FillMacro failed; gave result %s", result)
   }
 }
+
+/* Test that Fate dice in a macro aren't mistaken for its sixth input */
+func TestFillMacroFateDice(t *testing.T) {
+  inputs := []string{"1", "2", "3", "4", "5", "6"}
+  if result := FillMacro("4dF + A + F", inputs); result != "4dF + 1 + 6" {
+    t.Fatalf("FillMacro failed; gave result %s", result)
+  }
+
+  if err := ValidateMacro("4dF + A", EvalOptions{}); err != nil {
+    t.Fatalf("Validating a macro with Fate dice failed with error: %s", err)
+  }
+  if err := ValidateMacro("4dF!!", EvalOptions{}); err == nil {
+    t.Fatalf("Validating a macro with exploding Fate dice should fail")
+  }
+}
+
+/* Test that labels and custom dice names in a macro are left as they are */
+func TestFillMacroNames(t *testing.T) {
+  inputs := []string{"1", "2", "3", "4", "5", "6"}
+  if result := FillMacro("3d{Avalanche}[Fire] + A[Cold] + B", inputs); result != "3d{Avalanche}[Fire] + 1[Cold] + 2" {
+    t.Fatalf("FillMacro failed; gave result %s", result)
+  }
+
+  options := EvalOptions{CustomDice: map[string]CustomDie{"avalanche": mustDefineDie("avalanche", "0, 0, 1, 1, 2, 3")}}
+  if err := ValidateMacro("2d{Avalanche}[Fire] + A", options); err != nil {
+    t.Fatalf("Validating a macro with a custom die and a label failed with error: %s", err)
+  }
+}
+
+/* Test that the labels of rolls in a macro aren't read as inputs */
+func TestFillMacroSectionLabels(t *testing.T) {
+  result := FillMacro("Attack: d20+A; Damage: 2d6+B", []string{"5", "3"})
+  if result != "Attack: d20+5; Damage: 2d6+3" {
+    t.Fatalf("FillMacro failed; gave result %s", result)
+  }
+
+  if err := ValidateMacro("Attack: d20+A; Damage: 2d6+B", EvalOptions{}); err != nil {
+    t.Fatalf("Validating a macro with labelled rolls failed with error: %s", err)
+  }
+}
+
+/* Test repeated rolls in both forms */
+func TestRepeatedRolls(t *testing.T) {
+  cases := []struct {
+    expression string
+    faces []int
+    expected []int
+  }{
+    {"3x d6", []int{4, 1, 6}, []int{4, 1, 6}},
+    {"2x4d6kh3", []int{3, 1, 6, 4, 2, 2, 5, 5}, []int{13, 12}},
+    {"repeat(3, d20 + 2)", []int{1, 20, 10}, []int{3, 22, 12}},
+    {"2x (d4 + 1) * 2", []int{1, 4}, []int{4, 10}},
+    {"d20 + 5", []int{10}, []int{15}},
+  }
+
+  for _, c := range cases {
+    rows, err := ParseRepeatedExpression(c.expression, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, err)
+    }
+    results := []int{}
+    for _, row := range rows {
+      results = append(results, row.Result)
+    }
+    if !slices.Equal(results, c.expected) {
+      t.Fatalf("Roll %s with %v: got %v instead of %v", c.expression, c.faces, results, c.expected)
+    }
+  }
+
+  rows, _ := ParseRepeatedExpression("2x 4d6kh3", EvalOptions{Roller: &scriptedRoller{faces: []int{3, 1, 6, 4, 2, 2, 5, 5}}})
+  if len(rows[1].Rolls) != 1 || !slices.Equal(rows[1].Rolls[0].Results, []int{2, 2, 5, 5}) {
+    t.Fatalf("Second row of 2x 4d6kh3 should only have its own dice, but had %v", rows[1].Rolls)
+  }
+}
+
+/* Test that repeat counts are checked, and repeats are only allowed for a whole roll */
+func TestRepeatedRollErrors(t *testing.T) {
+  cases := []struct {
+    expression string
+    column int
+  }{
+    {"0x d6", 1},
+    {"21x d6", 1},
+    {"repeat(d6, d6)", 8},
+    {"repeat(3 d6)", 10},
+    {"repeat(3, d6", 13},
+    {"3x", 3},
+    {"repeat(6, 4d6kh3) + 1", 19},
+  }
+
+  for _, c := range cases {
+    _, err := ParseRepeatedExpression(c.expression, EvalOptions{})
+    var parseError *ParseError
+    if !errors.As(err, &parseError) || parseError.Column != c.column {
+      t.Fatalf("Parsing %s should fail at column %d, but got: %v", c.expression, c.column, err)
+    }
+  }
+
+  if _, err := ParseRepeatedExpression("repeat(6, 4d6kh3) + 1", EvalOptions{}); !strings.Contains(err.Error(), "after a repeated roll") {
+    t.Fatalf("Adding to a repeated roll should say nothing can come after it, but got: %v", err)
+  }
+
+  if _, _, err := ParseExpression("6x 4d6kh3"); err == nil {
+    t.Fatalf("ParseExpression should not accept a repeated roll")
+  }
+  if _, err := AnalyzeExpression("repeat(2, d6)", EvalOptions{}); err == nil {
+    t.Fatalf("AnalyzeExpression should not accept a repeated roll")
+  }
+}
+
+/* Test sorting the rows of a repeated roll */
+func TestSortRows(t *testing.T) {
+  rows, _ := ParseRepeatedExpression("4x d20", EvalOptions{Roller: &scriptedRoller{faces: []int{7, 19, 2, 11}}})
+
+  SortRows(rows, false)
+  if rows[0].Result != 2 || rows[3].Result != 19 {
+    t.Fatalf("Sorting ascending gave %v", rows)
+  }
+  SortRows(rows, true)
+  if rows[0].Result != 19 || rows[3].Result != 2 || rows[0].Rolls[0].Results[0] != 19 {
+    t.Fatalf("Sorting descending gave %v", rows)
+  }
+}
+
+/* Test that labels are carried onto the dice they apply to */
+func TestLabels(t *testing.T) {
+  cases := []struct {
+    expression string
+    expected int
+    labels []string
+  }{
+    {"d6[fire]", 3, []string{"fire"}},
+    {"2d6[slashing] + d6[fire] + 4", 14, []string{"slashing", "fire"}},
+    {"2d6 + d6 + 4 [slashing]", 14, []string{"slashing", "slashing"}},
+    {"2d6 + d6 + 4[slashing]", 14, []string{"", ""}},
+    {"(2d6 + d6[fire])[magic] * 2", 20, []string{"magic", "fire"}},
+    {"-d6[ cold ]", -3, []string{"cold"}},
+  }
+
+  for _, c := range cases {
+    faces := []int{3, 4, 3}
+    result, rolls, err := ParseExpressionWithOptions(c.expression, EvalOptions{Roller: &scriptedRoller{faces: faces}})
+    if err != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, err)
+    }
+    if result != c.expected {
+      t.Fatalf("Roll %s: got %d instead of %d", c.expression, result, c.expected)
+    }
+    labels := []string{}
+    for _, r := range rolls {
+      labels = append(labels, r.Label)
+    }
+    if !slices.Equal(labels, c.labels) {
+      t.Fatalf("Roll %s: labels were %q instead of %q", c.expression, labels, c.labels)
+    }
+  }
+
+  for expression, column := range map[string]int{"d6[fire": 3, "d6[]": 3, "[fire]": 1, "d6[fire] 3": 10} {
+    _, _, err := ParseExpression(expression)
+    var parseError *ParseError
+    if !errors.As(err, &parseError) || parseError.Column != column {
+      t.Fatalf("Parsing %s should fail at column %d, but got: %v", expression, column, err)
+    }
+  }
+}
+
+/* Test splitting several labelled rolls apart */
+func TestSplitRolls(t *testing.T) {
+  cases := []struct {
+    input string
+    expected []LabelledRoll
+  }{
+    {"d20+7", []LabelledRoll{{"", "d20+7"}}},
+    {"Attack: d20+7; Damage: 2d6+4 [slashing]", []LabelledRoll{{"Attack", "d20+7"}, {"Damage", "2d6+4 [slashing]"}}},
+    {"d20; Sneak attack : 3d6;", []LabelledRoll{{"", "d20"}, {"Sneak attack", "3d6"}}},
+    {"d20 >= 10 ? 1 : 0", []LabelledRoll{{"", "d20 >= 10 ? 1 : 0"}}},
+    {"Stats: 6x 4d6kh3", []LabelledRoll{{"Stats", "6x 4d6kh3"}}},
+    {" ; ", []LabelledRoll{}},
+  }
+
+  for _, c := range cases {
+    rolls := SplitRolls(c.input)
+    if !slices.Equal(rolls, c.expected) {
+      t.Fatalf("Splitting %q gave %q instead of %q", c.input, rolls, c.expected)
+    }
+  }
+}
+
+/* Test the subtotal of each label, including halve() and double() */
+func TestLabelTotals(t *testing.T) {
+  cases := []struct {
+    expression string
+    faces []int
+    expected int
+    totals []LabelTotal
+  }{
+    {"2d6[slashing] + 3d6[fire] + 4", []int{3, 4, 1, 5, 6}, 23, []LabelTotal{{"slashing", 7}, {"fire", 12}}},
+    {"d6[fire] + d4[cold] + d6[fire]", []int{2, 3, 5}, 10, []LabelTotal{{"fire", 7}, {"cold", 3}}},
+    {"2d6 + 4 [slashing]", []int{3, 4}, 11, []LabelTotal{{"slashing", 11}}},
+    {"(2d6 + d4[fire])[magic]", []int{3, 4, 2}, 9, []LabelTotal{{"fire", 2}, {"magic", 7}}},
+    {"2d6[slashing] + halve(3d6[fire]) + 4", []int{3, 4, 1, 5, 5}, 16, []LabelTotal{{"slashing", 7}, {"fire", 5}}},
+    {"halve(2d6[slashing] + 3d6[fire])", []int{3, 4, 1, 5, 5}, 9, []LabelTotal{{"slashing", 3}, {"fire", 5}}},
+    {"d8[cold] + double(d8[radiant])", []int{3, 5}, 13, []LabelTotal{{"cold", 3}, {"radiant", 10}}},
+    {"d20 + 5", []int{10}, 15, nil},
+    {"10 - d6[fire]", []int{6}, 4, []LabelTotal{{"fire", -6}}},
+    {"d8[cold] - (d6[fire] + 1)", []int{5, 3}, 1, []LabelTotal{{"cold", 5}, {"fire", -3}}},
+    {"-d4[acid] + 5", []int{3}, 2, []LabelTotal{{"acid", -3}}},
+    {"d6[fire] * 3", []int{6}, 18, []LabelTotal{{"fire", 18}}},
+    {"2 * (d6[fire] + d4[cold])", []int{5, 2}, 14, []LabelTotal{{"fire", 10}, {"cold", 4}}},
+    {"(d6[fire] + d6[cold]) / 2", []int{4, 2}, 3, []LabelTotal{{"fire", 2}, {"cold", 1}}},
+    {"floor(d6[fire] * 3 / 2)", []int{3}, 4, []LabelTotal{{"fire", 4}}},
+  }
+
+  for _, c := range cases {
+    rows, err := ParseRepeatedExpression(c.expression, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, err)
+    }
+    if rows[0].Result != c.expected {
+      t.Fatalf("Roll %s with %v: got %d instead of %d", c.expression, c.faces, rows[0].Result, c.expected)
+    }
+    if !slices.Equal(rows[0].Labels, c.totals) {
+      t.Fatalf("Roll %s with %v: label totals were %v instead of %v", c.expression, c.faces, rows[0].Labels, c.totals)
+    }
+  }
+
+  // Labels can't go anywhere their subtotal wouldn't add up to the result
+  for _, expression := range []string{"max(d6[fire], d6[cold])", "abs(d6[fire] - 4)", "d6[fire] >= 3", "d6[fire] * d6[cold]", "10 / d6[fire]", "d20[hit] >= 10 ? 2d6 : 0"} {
+    if _, err := ParseRepeatedExpression(expression, EvalOptions{}); err == nil {
+      t.Fatalf("Parsing %s should have failed", expression)
+    }
+  }
+}
+
+/* Test rolling Fate dice */
+func TestFateDice(t *testing.T) {
+  cases := []struct {
+    expression string
+    faces []int
+    expected int
+    results []int
+  }{
+    {"4dF", []int{1, 2, 3, 3}, 1, []int{-1, 0, 1, 1}},
+    {"4dF+2", []int{3, 3, 3, 3}, 6, []int{1, 1, 1, 1}},
+    {"dF", []int{1}, -1, []int{-1}},
+    {"4df", []int{2, 2, 2, 1}, -1, []int{0, 0, 0, -1}},
+    {"5dFkh4", []int{1, 3, 2, 1, 3}, 1, []int{-1, 1, 0, -1, 1}},
+  }
+
+  for _, c := range cases {
+    result, rolls, err := ParseExpressionWithOptions(c.expression, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, err)
+    }
+    if result != c.expected {
+      t.Fatalf("Roll %s with %v: got %d instead of %d", c.expression, c.faces, result, c.expected)
+    }
+    if !rolls[0].Fate || !slices.Equal(rolls[0].Results, c.results) {
+      t.Fatalf("Roll %s with %v: rolled %v (Fate: %t) instead of Fate dice %v", c.expression, c.faces, rolls[0].Results, rolls[0].Fate, c.results)
+    }
+  }
+
+  for _, expression := range []string{"4dF!!", "4dFr<0", "4dF>=1", "30dF"} {
+    if _, _, err := ParseExpression(expression); err == nil {
+      t.Fatalf("Parsing %s should have failed", expression)
+    }
+  }
+}
+
+/* Test rolling custom dice, whose numeric faces add up and whose named faces are tallied */
+func TestCustomDice(t *testing.T) {
+  customDice := map[string]CustomDie{
+    "avalanche": mustDefineDie("avalanche", "0, 0, 1, 1, 2, 3"),
+    "location": mustDefineDie("location", "head, torso, left arm, right arm, legs, 1+legs"),
+  }
+  cases := []struct {
+    expression string
+    faces []int
+    critical bool
+    expected int
+    results []int
+    tally map[string]int
+  }{
+    {"3d{avalanche}", []int{6, 3, 5}, false, 6, []int{3, 1, 2}, map[string]int{}},
+    {"3d{Avalanche}kh1+1", []int{1, 5, 4}, false, 3, []int{0, 2, 1}, map[string]int{}},
+    {"d{ avalanche }", []int{1, 3}, true, 1, []int{0, 1}, map[string]int{}},
+    {"2d{location}", []int{1, 6}, false, 1, []int{0, 1}, map[string]int{"head": 1, "legs": 1}},
+    {"3d{location}kh1", []int{6, 3, 2}, false, 1, []int{1, 0, 0}, map[string]int{"legs": 1}},
+  }
+
+  for _, c := range cases {
+    options := EvalOptions{Roller: &scriptedRoller{faces: c.faces}, Critical: c.critical, CustomDice: customDice}
+    result, rolls, err := ParseExpressionWithOptions(c.expression, options)
+    if err != nil {
+      t.Fatalf("Parsing %s failed with error: %s", c.expression, err)
+    }
+    if result != c.expected || !slices.Equal(rolls[0].Results, c.results) {
+      t.Fatalf("Roll %s with %v: got %d from %v instead of %d from %v", c.expression, c.faces, result, rolls[0].Results, c.expected, c.results)
+    }
+    if tally := TallySymbols(rolls); !maps.Equal(tally, c.tally) {
+      t.Fatalf("Roll %s with %v: tallied %v instead of %v", c.expression, c.faces, tally, c.tally)
+    }
+  }
+
+  for _, expression := range []string{"d{}", "d{avalanche", "3d{avalanche}!!", "3d{avalanche}r<1", "3d{avalanche}>=2", "d{glacier}"} {
+    if _, _, err := ParseExpressionWithOptions(expression, EvalOptions{CustomDice: customDice}); err == nil {
+      t.Fatalf("Parsing %s should have failed", expression)
+    }
+  }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "os"
+  "strings"
+  "time"
+)
+
+/* A record of a roll, which can be signed so that anyone can later
+ * check that the bot really made the roll and nothing was changed.
+ */
+type RollReceipt struct {
+  Expression string `json:"e"`
+  Result int `json:"r"`
+  // The result of each row of a repeated roll, or of each of several
+  // rolls made together, in which case Result is their total
+  Rows []int `json:"rs,omitempty"`
+  // The results of each DiceRoll, in the order they were rolled
+  Rolls [][]int `json:"d"`
+  Timestamp int64 `json:"t"`
+  Guild string `json:"g"`
+  User string `json:"u"`
+}
+
+/* Builds a receipt for a roll that was just made.
+ */
+func NewRollReceipt(expression string, result int, rolls []DiceRoll, guild string, user string) RollReceipt {
+  receipt := RollReceipt{
+    Expression: expression,
+    Result: result,
+    Rolls: [][]int{},
+    Timestamp: time.Now().Unix(),
+    Guild: guild,
+    User: user,
+  }
+  for _, r := range rolls {
+    receipt.Rolls = append(receipt.Rolls, r.Results)
+  }
+  return receipt
+}
+
+/* Builds a receipt for a roll that may have been repeated, or made
+ * up of several rolls. A single roll that wasn't repeated gets the same receipt as NewRollReceipt.
+ */
+func NewRepeatedRollReceipt(expression string, rows []RollRow, guild string, user string) RollReceipt {
+  if len(rows) == 1 {
+    return NewRollReceipt(expression, rows[0].Result, rows[0].Rolls, guild, user)
+  }
+
+  receipt := NewRollReceipt(expression, 0, []DiceRoll{}, guild, user)
+  receipt.Rows = []int{}
+  for _, row := range rows {
+    receipt.Result += row.Result
+    receipt.Rows = append(receipt.Rows, row.Result)
+    for _, r := range row.Rolls {
+      receipt.Rolls = append(receipt.Rolls, r.Results)
+    }
+  }
+  return receipt
+}
+
+/* Loads the secret key receipts are signed with from the environment.
+ */
+func receiptKey() ([]byte, error) {
+  key := os.Getenv("RECEIPT_SECRET")
+  if key == "" {
+    return nil, errors.New("Roll receipts are not set up on this bot (RECEIPT_SECRET is missing)")
+  }
+  return []byte(key), nil
+}
+
+/* Signs a receipt with HMAC-SHA256, producing a code of the form
+ * <payload>.<signature>, both base64 encoded.
+ */
+func SignReceipt(receipt RollReceipt, key []byte) (string, error) {
+  payload, err := json.Marshal(receipt)
+  if err != nil {
+    return "", err
+  }
+
+  mac := hmac.New(sha256.New, key)
+  mac.Write(payload)
+
+  encoding := base64.RawURLEncoding
+  return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+/* Checks a receipt code made by SignReceipt, returning the receipt
+ * if the signature is valid.
+ */
+func VerifyReceipt(code string, key []byte) (*RollReceipt, error) {
+  encodedPayload, encodedSignature, found := strings.Cut(strings.TrimSpace(code), ".")
+  if !found {
+    return nil, errors.New("This is not a roll receipt")
+  }
+
+  encoding := base64.RawURLEncoding
+  payload, err := encoding.DecodeString(encodedPayload)
+  if err != nil {
+    return nil, errors.New("This is not a roll receipt")
+  }
+  signature, err := encoding.DecodeString(encodedSignature)
+  if err != nil {
+    return nil, errors.New("This is not a roll receipt")
+  }
+
+  mac := hmac.New(sha256.New, key)
+  mac.Write(payload)
+  if !hmac.Equal(signature, mac.Sum(nil)) {
+    return nil, errors.New("The receipt's signature does not match, so it was not made by this bot or has been changed")
+  }
+
+  var receipt RollReceipt
+  if err := json.Unmarshal(payload, &receipt); err != nil {
+    return nil, errors.New("The receipt could not be read")
+  }
+  return &receipt, nil
+}
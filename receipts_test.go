@@ -0,0 +1,69 @@
+package main
+
+import (
+  "slices"
+  "strings"
+  "testing"
+)
+
+/* Test that a signed receipt verifies and keeps its contents */
+func TestReceiptRoundTrip(t *testing.T) {
+  key := []byte("secret")
+  result, rolls, _ := ParseExpressionWithOptions("2d6 + d20", EvalOptions{Roller: NewRoller(7)})
+  receipt := NewRollReceipt("2d6 + d20", result, rolls, "guild", "user")
+
+  code, err := SignReceipt(receipt, key)
+  if err != nil {
+    t.Fatalf("Signing receipt failed with error: %s", err)
+  }
+
+  verified, err := VerifyReceipt(code, key)
+  if err != nil {
+    t.Fatalf("Verifying receipt failed with error: %s", err)
+  }
+
+  if verified.Expression != "2d6 + d20" || verified.Result != result || verified.Guild != "guild" || verified.User != "user" {
+    t.Fatalf("Verified receipt %+v does not match the original %+v", verified, receipt)
+  }
+  if len(verified.Rolls) != 2 || !slices.Equal(verified.Rolls[0], rolls[0].Results) {
+    t.Fatalf("Verified receipt rolls %v do not match the original", verified.Rolls)
+  }
+}
+
+/* Test that tampered receipts and receipts signed with another key are rejected */
+func TestReceiptTampered(t *testing.T) {
+  key := []byte("secret")
+  code, _ := SignReceipt(RollReceipt{Expression: "d20", Result: 3, Rolls: [][]int{{3}}}, key)
+
+  forged, _ := SignReceipt(RollReceipt{Expression: "d20", Result: 20, Rolls: [][]int{{20}}}, []byte("other"))
+  if _, err := VerifyReceipt(forged, key); err == nil {
+    t.Fatalf("Receipt signed with another key should not verify")
+  }
+
+  payload, signature, _ := strings.Cut(code, ".")
+  otherPayload, _, _ := strings.Cut(forged, ".")
+  if _, err := VerifyReceipt(otherPayload + "." + signature, key); err == nil {
+    t.Fatalf("Receipt with a changed payload should not verify")
+  }
+  if _, err := VerifyReceipt(payload, key); err == nil {
+    t.Fatalf("Receipt without a signature should not verify")
+  }
+}
+
+/* Test that a receipt for a repeated roll keeps every row */
+func TestRepeatedReceipt(t *testing.T) {
+  rows, _ := ParseRepeatedExpression("3x 2d6", EvalOptions{Roller: NewRoller(3)})
+  receipt := NewRepeatedRollReceipt("3x 2d6", rows, "guild", "user")
+
+  if len(receipt.Rows) != 3 || len(receipt.Rolls) != 3 {
+    t.Fatalf("Receipt for 3x 2d6 had rows %v and dice %v", receipt.Rows, receipt.Rolls)
+  }
+  if receipt.Result != rows[0].Result + rows[1].Result + rows[2].Result {
+    t.Fatalf("Receipt total %d is not the total of the rows %v", receipt.Result, receipt.Rows)
+  }
+
+  single, _ := ParseRepeatedExpression("2d6", EvalOptions{Roller: NewRoller(3)})
+  if receipt := NewRepeatedRollReceipt("2d6", single, "guild", "user"); receipt.Rows != nil {
+    t.Fatalf("Receipt for a roll that wasn't repeated should not have rows, but had %v", receipt.Rows)
+  }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+  cryptorand "crypto/rand"
+  "math/big"
+  "math/rand"
+  "sync"
+  "time"
+)
+
+/* A source of random numbers for rolling dice.
+ * *rand.Rand satisfies this, so tests can pass in a seeded generator,
+ * or any other type that returns the rolls they want.
+ */
+type Roller interface {
+  // Returns a random number from 0 up to (but not including) n
+  Intn(n int) int
+}
+
+/* A Roller that can safely be shared between goroutines.
+ */
+type lockedRoller struct {
+  mu sync.Mutex
+  rng *rand.Rand
+}
+
+/* Creates a goroutine-safe Roller seeded with the given seed.
+ */
+func NewRoller(seed int64) Roller {
+  return &lockedRoller{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRoller) Intn(n int) int {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.rng.Intn(n)
+}
+
+// The Roller used when no other is given, seeded once at startup
+var defaultRoller = NewRoller(time.Now().UnixNano())
+
+/* A Roller backed by crypto/rand, for servers that want rolls nobody
+ * could predict or reproduce. It is safe to share between goroutines.
+ */
+type cryptoRoller struct{}
+
+func (cryptoRoller) Intn(n int) int {
+  value, err := cryptorand.Int(cryptorand.Reader, big.NewInt(int64(n)))
+  if err != nil {
+    // The system's secure random source should never fail; if it does,
+    // there is no safe way to roll, so fail loudly
+    panic(err)
+  }
+  return int(value.Int64())
+}
+
+// The Roller used by servers that have turned on secure rolls
+var secureRoller Roller = cryptoRoller{}
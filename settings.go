@@ -0,0 +1,56 @@
+package main
+
+import (
+  "errors"
+
+  "gorm.io/gorm"
+)
+
+/* Per-server settings that change how expressions are rolled.
+ */
+type GuildSettings struct {
+  gorm.Model
+  Guild string
+  Rounding string
+  // Roll with crypto/rand, and attach a signed receipt to every roll
+  SecureRolls bool
+}
+
+/* Finds the settings for the given server.
+ * If the server has never changed its settings, the defaults are returned.
+ */
+func FindGuildSettings(guild string) (*GuildSettings, error) {
+  var settings GuildSettings
+
+  result := db.Where("Guild = ?", guild).First(&settings)
+  if result.Error != nil {
+    if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+      return &GuildSettings{Guild: guild, Rounding: "floor"}, nil
+    }
+    return nil, errors.New("Database error")
+  }
+
+  return &settings, nil
+}
+
+func SaveGuildSettings(settings *GuildSettings) {
+  db.Save(settings)
+}
+
+/* Builds the options for evaluating an expression in the given server,
+ * including the custom dice it has made.
+ * Falls back to the default settings if they can't be loaded.
+ */
+func GuildEvalOptions(guild string) EvalOptions {
+  options := EvalOptions{CustomDice: LoadCustomDice(guild)}
+  settings, err := FindGuildSettings(guild)
+  if err != nil {
+    return options
+  }
+
+  options.Rounding = settings.Rounding
+  if settings.SecureRolls {
+    options.Roller = secureRoller
+  }
+  return options
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "math/rand"
+  "runtime"
+  "slices"
+  "sync"
+  "time"
+)
+
+// How many times an expression is rolled when no count is given
+const defaultSimulationIterations = 10000
+
+// The most times a single simulation may roll an expression
+const maxSimulationIterations = 1000000
+
+// The longest a single simulation may run for, so that it can't hold up
+// the bot. Discord expects a reply within 3 seconds.
+const maxSimulationTime = 2 * time.Second
+
+// How many rolls each worker makes between checks of the time limit
+const simulationCheckInterval = 1024
+
+/* Options for simulating an expression.
+ * Any option left as zero uses its default: 10000 iterations, one worker
+ * per CPU, the longest time allowed, and a seed taken from the clock.
+ */
+type SimulationOptions struct {
+  Rounding string
+  Iterations int
+  Workers int
+  TimeLimit time.Duration
+  // Each worker rolls with its own generator, seeded with Seed plus its index
+  Seed int64
+  // The custom dice that can be rolled with d{name}, as in EvalOptions
+  CustomDice map[string]CustomDie
+}
+
+/* The results of rolling an expression many times.
+ * Counts holds how many times each result was rolled. If the time limit
+ * ran out, Iterations is the number of rolls that were finished.
+ */
+type SimulationResult struct {
+  Iterations int
+  TimedOut bool
+  Counts map[int]int
+  sum float64
+  sumOfSquares float64
+}
+
+/* Rolls the given expression many times and collects the results,
+ * spreading the rolls across several goroutines. It accepts the same
+ * grammar as ParseExpression, including exploding dice that can't be
+ * analysed exactly. If any roll fails, such as by dividing by zero,
+ * the simulation stops and returns that error.
+ */
+func Simulate(input string, options SimulationOptions) (*SimulationResult, error) {
+  tree, err := parse(input)
+  if err != nil {
+    return nil, err
+  }
+
+  if options.Rounding == "" {
+    options.Rounding = "floor"
+  }
+  if options.Iterations <= 0 {
+    options.Iterations = defaultSimulationIterations
+  }
+  if options.Iterations > maxSimulationIterations {
+    return nil, errors.New(fmt.Sprintf("Simulations are limited to %d rolls", maxSimulationIterations))
+  }
+  if options.Workers <= 0 {
+    options.Workers = runtime.NumCPU()
+  }
+  options.Workers = min(options.Workers, options.Iterations)
+  if options.TimeLimit <= 0 || options.TimeLimit > maxSimulationTime {
+    options.TimeLimit = maxSimulationTime
+  }
+  if options.Seed == 0 {
+    options.Seed = time.Now().UnixNano()
+  }
+
+  deadline := time.Now().Add(options.TimeLimit)
+  results := make([]*SimulationResult, options.Workers)
+  errs := make([]error, options.Workers)
+  var failed sync.Once
+  stop := make(chan struct{})
+
+  var wg sync.WaitGroup
+  for w := 0; w < options.Workers; w++ {
+    // Share the rolls out evenly, giving any remainder to the first workers
+    iterations := options.Iterations / options.Workers
+    if w < options.Iterations % options.Workers {
+      iterations++
+    }
+
+    wg.Add(1)
+    go func(w int, iterations int) {
+      defer wg.Done()
+      result, err := simulateWorker(tree, options.Rounding, options.CustomDice, rand.New(rand.NewSource(options.Seed + int64(w))), iterations, deadline, stop)
+      results[w], errs[w] = result, err
+      if err != nil {
+        failed.Do(func() { close(stop) })
+      }
+    }(w, iterations)
+  }
+  wg.Wait()
+
+  for _, err := range errs {
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  total := &SimulationResult{Counts: map[int]int{}}
+  for _, result := range results {
+    total.Iterations += result.Iterations
+    total.TimedOut = total.TimedOut || result.TimedOut
+    total.sum += result.sum
+    total.sumOfSquares += result.sumOfSquares
+    for value, count := range result.Counts {
+      total.Counts[value] += count
+    }
+  }
+  if total.Iterations == 0 {
+    return nil, errors.New("The simulation ran out of time before finishing any rolls")
+  }
+  return total, nil
+}
+
+/* Rolls an expression the given number of times on one goroutine,
+ * stopping early if the deadline passes or another worker fails.
+ */
+func simulateWorker(tree Node, rounding string, customDice map[string]CustomDie, roller Roller, iterations int, deadline time.Time, stop chan struct{}) (*SimulationResult, error) {
+  result := &SimulationResult{Counts: map[int]int{}}
+  for i := 0; i < iterations; i++ {
+    if i % simulationCheckInterval == 0 {
+      select {
+      case <-stop:
+        return result, nil
+      default:
+      }
+      if time.Now().After(deadline) {
+        result.TimedOut = true
+        return result, nil
+      }
+    }
+
+    value, err := tree.eval(&evaluator{rolls: []DiceRoll{}, rounding: rounding, roller: roller, customDice: customDice})
+    if err != nil {
+      return nil, err
+    }
+    result.Iterations++
+    result.Counts[value]++
+    result.sum += float64(value)
+    result.sumOfSquares += float64(value) * float64(value)
+  }
+  return result, nil
+}
+
+/* The lowest result that was rolled.
+ */
+func (r *SimulationResult) Min() int {
+  return slices.Min(r.values())
+}
+
+/* The highest result that was rolled.
+ */
+func (r *SimulationResult) Max() int {
+  return slices.Max(r.values())
+}
+
+/* The average of the results that were rolled.
+ */
+func (r *SimulationResult) Mean() float64 {
+  return r.sum / float64(r.Iterations)
+}
+
+/* The standard deviation of the results that were rolled.
+ */
+func (r *SimulationResult) StdDev() float64 {
+  mean := r.Mean()
+  return math.Sqrt(max(r.sumOfSquares / float64(r.Iterations) - mean * mean, 0))
+}
+
+/* The fraction of rolls that came out as n or higher.
+ */
+func (r *SimulationResult) AtLeast(n int) float64 {
+  count := 0
+  for value, c := range r.Counts {
+    if value >= n {
+      count += c
+    }
+  }
+  return float64(count) / float64(r.Iterations)
+}
+
+/* The smallest result that at least p percent of the rolls were at or
+ * below, ex. Percentile(50) is the median.
+ */
+func (r *SimulationResult) Percentile(p float64) int {
+  values := r.values()
+  needed := int(math.Ceil(p / 100 * float64(r.Iterations)))
+  seen := 0
+  for _, value := range values {
+    seen += r.Counts[value]
+    if seen >= needed {
+      return value
+    }
+  }
+  return values[len(values)-1]
+}
+
+/* Every distinct result that was rolled, in order.
+ */
+func (r *SimulationResult) values() []int {
+  values := []int{}
+  for value := range r.Counts {
+    values = append(values, value)
+  }
+  slices.Sort(values)
+  return values
+}
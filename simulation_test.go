@@ -0,0 +1,97 @@
+package main
+
+import (
+  "math"
+  "testing"
+  "time"
+)
+
+/* Test that simulating an expression lands close to its exact odds */
+func TestSimulate(t *testing.T) {
+  result, err := Simulate("2d6", SimulationOptions{Iterations: 200000, Workers: 4, Seed: 1})
+  if err != nil {
+    t.Fatalf("Simulating 2d6 failed with error: %s", err)
+  }
+
+  if result.Iterations != 200000 || result.TimedOut {
+    t.Fatalf("Simulate 2d6: finished %d rolls (timed out: %t) instead of 200000", result.Iterations, result.TimedOut)
+  }
+  if result.Min() != 2 || result.Max() != 12 {
+    t.Fatalf("Simulate 2d6: range was %d to %d instead of 2 to 12", result.Min(), result.Max())
+  }
+  if math.Abs(result.Mean() - 7) > 0.05 {
+    t.Fatalf("Simulate 2d6: mean was %f, too far from 7", result.Mean())
+  }
+  if math.Abs(result.StdDev() - math.Sqrt(35.0/6)) > 0.05 {
+    t.Fatalf("Simulate 2d6: standard deviation was %f, too far from %f", result.StdDev(), math.Sqrt(35.0/6))
+  }
+  if math.Abs(result.AtLeast(10) - 6.0/36) > 0.01 {
+    t.Fatalf("Simulate 2d6: P(>=10) was %f, too far from %f", result.AtLeast(10), 6.0/36)
+  }
+  if result.Percentile(50) != 7 || result.Percentile(100) != 12 {
+    t.Fatalf("Simulate 2d6: median was %d and 100th percentile %d", result.Percentile(50), result.Percentile(100))
+  }
+}
+
+/* Test that expressions that can't be analysed exactly can be simulated */
+func TestSimulateExploding(t *testing.T) {
+  result, err := Simulate("d6!!", SimulationOptions{Iterations: 100000, Seed: 7})
+  if err != nil {
+    t.Fatalf("Simulating d6!! failed with error: %s", err)
+  }
+
+  // Every die that rolls a 6 adds another, so the mean is 3.5 * 6/5
+  if math.Abs(result.Mean() - 4.2) > 0.05 {
+    t.Fatalf("Simulate d6!!: mean was %f, too far from 4.2", result.Mean())
+  }
+  if result.Max() <= 6 {
+    t.Fatalf("Simulate d6!!: never exploded in 100000 rolls")
+  }
+}
+
+/* Test that the same seed and workers give the same results */
+func TestSimulateSeeded(t *testing.T) {
+  options := SimulationOptions{Iterations: 5000, Workers: 3, Seed: 42}
+  first, err := Simulate("4d6kh3 + d20", options)
+  if err != nil {
+    t.Fatalf("Simulating failed with error: %s", err)
+  }
+  second, _ := Simulate("4d6kh3 + d20", options)
+
+  if first.Mean() != second.Mean() || len(first.Counts) != len(second.Counts) {
+    t.Fatalf("Simulations with the same seed gave different results: %f and %f", first.Mean(), second.Mean())
+  }
+  for value, count := range first.Counts {
+    if second.Counts[value] != count {
+      t.Fatalf("Simulations with the same seed rolled %d a different number of times", value)
+    }
+  }
+}
+
+/* Test that errors and limits are reported */
+func TestSimulateErrors(t *testing.T) {
+  if _, err := Simulate("4d6kq", SimulationOptions{}); err == nil {
+    t.Fatalf("Simulating an invalid expression should fail")
+  }
+  if _, err := Simulate("10 / (d2 - 1)", SimulationOptions{Iterations: 1000, Seed: 1}); err == nil {
+    t.Fatalf("Simulating an expression that can divide by zero should fail")
+  }
+  if _, err := Simulate("d6", SimulationOptions{Iterations: maxSimulationIterations + 1}); err == nil {
+    t.Fatalf("Simulating more than the maximum number of rolls should fail")
+  }
+}
+
+/* Test that a simulation stops once it runs out of time */
+func TestSimulateTimeLimit(t *testing.T) {
+  start := time.Now()
+  result, err := Simulate("20d200e>=100", SimulationOptions{Iterations: maxSimulationIterations, Workers: 1, TimeLimit: time.Millisecond, Seed: 1})
+  if err != nil {
+    t.Fatalf("Simulating failed with error: %s", err)
+  }
+  if time.Since(start) > time.Second {
+    t.Fatalf("Simulation took %s despite a 1ms time limit", time.Since(start))
+  }
+  if !result.TimedOut || result.Iterations >= maxSimulationIterations {
+    t.Fatalf("Simulation should have timed out, but finished %d rolls", result.Iterations)
+  }
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "regexp"
+  "slices"
+  "strconv"
+  "strings"
+)
+
+/* One face of a die whose faces are defined rather than numbered.
+ * A face can have a number, which is added up like the faces of
+ * ordinary dice, and any number of symbols, which are tallied,
+ * ex. a success and an advantage.
+ */
+type DieFace struct {
+  Value int
+  Symbols []string
+  // The face as it was written, ex. success+advantage
+  Text string
+}
+
+/* A die with defined faces, each equally likely to come up.
+ */
+type CustomDie struct {
+  Name string
+  Faces []DieFace
+}
+
+// The most faces a defined die may have
+const maxDieFaces = 100
+
+/* Parses the faces of a die, separated by commas. Each face is a
+ * number, one or more symbols joined with +, or both, ex.
+ * "blank, success, success+advantage, 2, 1+hit". A blank face has
+ * nothing on it.
+ */
+func ParseDieFaces(definition string) ([]DieFace, error) {
+  faces := []DieFace{}
+  for _, part := range strings.Split(definition, ",") {
+    part = strings.TrimSpace(part)
+    face := DieFace{Symbols: []string{}, Text: strings.ToLower(part)}
+    if part == "" {
+      return nil, errors.New("Faces can't be empty. Write `blank` for a face with nothing on it")
+    }
+    if strings.EqualFold(part, "blank") {
+      faces = append(faces, face)
+      continue
+    }
+
+    for _, mark := range strings.Split(part, "+") {
+      mark = strings.TrimSpace(mark)
+      if value, err := strconv.Atoi(mark); err == nil {
+        face.Value += value
+        continue
+      }
+      if !symbolName.MatchString(mark) {
+        return nil, errors.New(fmt.Sprintf("'%s' is not a number or a symbol. Symbols are words, ex. success or left arm", mark))
+      }
+      face.Symbols = append(face.Symbols, strings.ToLower(mark))
+    }
+    faces = append(faces, face)
+  }
+
+  if len(faces) < 2 || len(faces) > maxDieFaces {
+    return nil, errors.New(fmt.Sprintf("A die must have between 2 and %d faces", maxDieFaces))
+  }
+  return faces, nil
+}
+
+// Matches the name of a symbol on a face, ex. advantage or left arm
+var symbolName = regexp.MustCompile(`^[A-Za-z][\w '-]*$`)
+
+/* Defines a die from its faces, panicking if the definition is invalid.
+ * Only meant for dice built into the bot.
+ */
+func mustDefineDie(name string, definition string) CustomDie {
+  faces, err := ParseDieFaces(definition)
+  if err != nil {
+    panic(fmt.Sprintf("die %s: %s", name, err))
+  }
+  return CustomDie{Name: name, Faces: faces}
+}
+
+/* Counts the symbols on every custom die in a roll that wasn't dropped.
+ */
+func TallySymbols(rolls []DiceRoll) map[string]int {
+  tally := map[string]int{}
+  for _, r := range rolls {
+    for i, face := range r.Faces {
+      if i < len(r.Flags) && r.Flags[i] & DieDropped != 0 {
+        continue
+      }
+      for _, symbol := range face.Symbols {
+        tally[symbol]++
+      }
+    }
+  }
+  return tally
+}
+
+/* Rolls a die with defined faces, returning which face came up
+ * (starting from 1).
+ */
+func (d CustomDie) roll(roller Roller) int {
+  return roller.Intn(len(d.Faces)) + 1
+}
+
+/* A game's set of symbol dice, along with which symbols cancel each
+ * other out, ex. a success cancels a failure in Genesys.
+ */
+type SymbolSystem struct {
+  Name string
+  // The dice, in the order they're shown
+  Dice []CustomDie
+  // Shorter names for the dice, ex. a for ability
+  Aliases map[string]string
+  // The symbols, in the order they're reported
+  Symbols []string
+  // Pairs of symbols that cancel each other out, one for one
+  Cancels [][2]string
+  // The symbol that must be left over for a check to succeed, if any
+  Success string
+}
+
+// The narrative dice used by Genesys and Star Wars
+var genesysSystem = SymbolSystem{
+  Name: "Genesys",
+  Dice: []CustomDie{
+    mustDefineDie("proficiency", "blank, success, success, success+success, success+success, advantage, success+advantage, success+advantage, success+advantage, advantage+advantage, advantage+advantage, triumph+success"),
+    mustDefineDie("ability", "blank, success, success, success+success, advantage, advantage, success+advantage, advantage+advantage"),
+    mustDefineDie("boost", "blank, blank, success, success+advantage, advantage+advantage, advantage"),
+    mustDefineDie("challenge", "blank, failure, failure, failure+failure, failure+failure, threat, threat, failure+threat, failure+threat, threat+threat, threat+threat, despair+failure"),
+    mustDefineDie("difficulty", "blank, failure, failure+failure, threat, threat, threat, threat+threat, failure+threat"),
+    mustDefineDie("setback", "blank, blank, failure, failure, threat, threat"),
+    mustDefineDie("force", "dark, dark, dark, dark, dark, dark, dark+dark, light, light, light+light, light+light, light+light"),
+  },
+  Aliases: map[string]string{
+    "p": "proficiency",
+    "a": "ability",
+    "b": "boost",
+    "c": "challenge",
+    "d": "difficulty",
+    "s": "setback",
+    "f": "force",
+  },
+  Symbols: []string{"success", "failure", "advantage", "threat", "triumph", "despair", "light", "dark"},
+  Cancels: [][2]string{{"success", "failure"}, {"advantage", "threat"}},
+  Success: "success",
+}
+
+// The most dice a single pool may have
+const maxPoolDice = 50
+
+/* Some number of one kind of die in a pool. */
+type poolDice struct {
+  Die CustomDie
+  Count int
+}
+
+// Matches one kind of die in a pool, ex. 2a, 3 ability or p
+var poolPattern = regexp.MustCompile(`(\d*)\s*([A-Za-z]+)`)
+
+/* Parses a pool of dice, such as "2a 1p 2d" or "3 ability + 2 difficulty".
+ * Dice may be named in full or by their alias, and are returned in the
+ * system's order.
+ */
+func (s SymbolSystem) ParsePool(pool string) ([]poolDice, error) {
+  counts := map[string]int{}
+  total := 0
+  last := 0
+  for _, match := range poolPattern.FindAllStringSubmatchIndex(pool, -1) {
+    if gap := strings.Trim(pool[last:match[0]], " +,"); gap != "" {
+      return nil, errors.New(fmt.Sprintf("'%s' is not a number of dice, ex. 2a or 3 ability", gap))
+    }
+    last = match[1]
+
+    count := 1
+    if match[2] != match[3] {
+      var err error
+      count, err = strconv.Atoi(pool[match[2]:match[3]])
+      if err != nil {
+        count = maxPoolDice + 1
+      }
+    }
+    name := strings.ToLower(pool[match[4]:match[5]])
+    if alias, ok := s.Aliases[name]; ok {
+      name = alias
+    }
+    if _, ok := s.die(name); !ok {
+      return nil, errors.New(fmt.Sprintf("%s has no '%s' dice", s.Name, pool[match[4]:match[5]]))
+    }
+    counts[name] += count
+    total += count
+    if total > maxPoolDice {
+      return nil, errors.New(fmt.Sprintf("A pool can have at most %d dice", maxPoolDice))
+    }
+  }
+  if gap := strings.Trim(pool[last:], " +,"); gap != "" {
+    return nil, errors.New(fmt.Sprintf("'%s' is not a number of dice, ex. 2a or 3 ability", gap))
+  }
+  if total == 0 {
+    return nil, errors.New("The pool needs at least one die")
+  }
+
+  dice := []poolDice{}
+  for _, die := range s.Dice {
+    if counts[die.Name] > 0 {
+      dice = append(dice, poolDice{Die: die, Count: counts[die.Name]})
+    }
+  }
+  return dice, nil
+}
+
+/* Finds one of the system's dice by name. */
+func (s SymbolSystem) die(name string) (CustomDie, bool) {
+  for _, die := range s.Dice {
+    if die.Name == name {
+      return die, true
+    }
+  }
+  return CustomDie{}, false
+}
+
+/* The result of rolling a pool of symbol dice.
+ * Rolls has one entry for each kind of die, whose Results are the faces
+ * that came up (starting from 1) and whose Label is the die's name.
+ * Totals counts every symbol rolled, and Net what's left once they've
+ * cancelled each other out.
+ */
+type SymbolResult struct {
+  Rolls []DiceRoll
+  Faces [][]DieFace
+  Totals map[string]int
+  Net map[string]int
+  // Whether the check succeeded; only meaningful if Checked
+  Succeeded bool
+  // Whether any die in the pool could add to or cancel the success symbol
+  Checked bool
+}
+
+/* Rolls a pool of symbol dice, such as "2a 1p 2d". */
+func (s SymbolSystem) Roll(pool string, options EvalOptions) (*SymbolResult, error) {
+  dice, err := s.ParsePool(pool)
+  if err != nil {
+    return nil, err
+  }
+  roller := options.Roller
+  if roller == nil {
+    roller = defaultRoller
+  }
+
+  result := &SymbolResult{Rolls: []DiceRoll{}, Totals: map[string]int{}}
+  for _, d := range dice {
+    roll := DiceRoll{Expression: fmt.Sprintf("%d %s", d.Count, d.Die.Name), Sides: len(d.Die.Faces), Label: d.Die.Name}
+    faces := []DieFace{}
+    for i := 0; i < d.Count; i++ {
+      face := d.Die.roll(roller)
+      roll.Results = append(roll.Results, face)
+      faces = append(faces, d.Die.Faces[face-1])
+      for _, symbol := range d.Die.Faces[face-1].Symbols {
+        result.Totals[symbol]++
+      }
+    }
+    result.Rolls = append(result.Rolls, roll)
+    result.Faces = append(result.Faces, faces)
+    result.Checked = result.Checked || (s.Success != "" && s.affectsSuccess(d.Die))
+  }
+
+  result.Net = s.NetSymbols(result.Totals)
+  result.Succeeded = s.Success != "" && result.Net[s.Success] > 0
+  return result, nil
+}
+
+/* Cancels out the symbols in a tally, one for one, leaving only
+ * whichever of each pair there was more of.
+ */
+func (s SymbolSystem) NetSymbols(totals map[string]int) map[string]int {
+  net := map[string]int{}
+  for symbol, count := range totals {
+    net[symbol] = count
+  }
+  for _, pair := range s.Cancels {
+    cancelled := min(net[pair[0]], net[pair[1]])
+    net[pair[0]] -= cancelled
+    net[pair[1]] -= cancelled
+  }
+  for symbol, count := range net {
+    if count == 0 {
+      delete(net, symbol)
+    }
+  }
+  return net
+}
+
+/* Checks whether a die can show the success symbol, or a symbol that
+ * cancels it.
+ */
+func (s SymbolSystem) affectsSuccess(die CustomDie) bool {
+  relevant := []string{s.Success}
+  for _, pair := range s.Cancels {
+    if slices.Contains(pair[:], s.Success) {
+      relevant = append(relevant, pair[:]...)
+    }
+  }
+  for _, face := range die.Faces {
+    for _, symbol := range face.Symbols {
+      if slices.Contains(relevant, symbol) {
+        return true
+      }
+    }
+  }
+  return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+  "maps"
+  "testing"
+)
+
+/* Test parsing the faces of dice with defined faces */
+func TestParseDieFaces(t *testing.T) {
+  faces, err := ParseDieFaces("blank, Success+advantage, 2, 1+hit")
+  if err != nil {
+    t.Fatalf("Parsing faces failed with error: %s", err)
+  }
+  expected := []DieFace{
+    {Value: 0, Symbols: []string{}},
+    {Value: 0, Symbols: []string{"success", "advantage"}},
+    {Value: 2, Symbols: []string{}},
+    {Value: 1, Symbols: []string{"hit"}},
+  }
+  for i, face := range faces {
+    if face.Value != expected[i].Value || len(face.Symbols) != len(expected[i].Symbols) {
+      t.Fatalf("Face %d was %v instead of %v", i + 1, face, expected[i])
+    }
+    for j, symbol := range face.Symbols {
+      if symbol != expected[i].Symbols[j] {
+        t.Fatalf("Face %d was %v instead of %v", i + 1, face, expected[i])
+      }
+    }
+  }
+
+  for _, definition := range []string{"", "1,,2", "success", "1, 2+@"} {
+    if _, err := ParseDieFaces(definition); err == nil {
+      t.Fatalf("Parsing the faces '%s' should fail", definition)
+    }
+  }
+}
+
+/* Test parsing pools of Genesys dice */
+func TestParsePool(t *testing.T) {
+  cases := []struct {
+    pool string
+    expected map[string]int
+  }{
+    {"2a 1p 2d", map[string]int{"ability": 2, "proficiency": 1, "difficulty": 2}},
+    {"3 ability + 2 Difficulty", map[string]int{"ability": 3, "difficulty": 2}},
+    {"2a1p", map[string]int{"ability": 2, "proficiency": 1}},
+    {"a, a, s", map[string]int{"ability": 2, "setback": 1}},
+  }
+
+  for _, c := range cases {
+    dice, err := genesysSystem.ParsePool(c.pool)
+    if err != nil {
+      t.Fatalf("Parsing the pool '%s' failed with error: %s", c.pool, err)
+    }
+    counts := map[string]int{}
+    for _, d := range dice {
+      counts[d.Die.Name] = d.Count
+    }
+    if !maps.Equal(counts, c.expected) {
+      t.Fatalf("The pool '%s' was parsed as %v instead of %v", c.pool, counts, c.expected)
+    }
+  }
+
+  for _, pool := range []string{"", "2x", "2a ?", "51a", "ability dice"} {
+    if _, err := genesysSystem.ParsePool(pool); err == nil {
+      t.Fatalf("Parsing the pool '%s' should fail", pool)
+    }
+  }
+}
+
+/* Test that symbols cancel each other out, except triumphs and despairs */
+func TestRollSymbols(t *testing.T) {
+  cases := []struct {
+    pool string
+    faces []int
+    net map[string]int
+    succeeded bool
+    checked bool
+  }{
+    // Two successes and a failure
+    {"a d", []int{4, 2}, map[string]int{"success": 1}, true, true},
+    // A success and an advantage against a failure and a threat
+    {"a d", []int{7, 8}, map[string]int{}, false, true},
+    // A triumph and a despair cancel each other's success and failure, but not each other
+    {"p c", []int{12, 12}, map[string]int{"triumph": 1, "despair": 1}, false, true},
+    {"b s", []int{5, 5}, map[string]int{"advantage": 1}, false, true},
+    // Force dice aren't a check
+    {"2f", []int{7, 10}, map[string]int{"dark": 2, "light": 2}, false, false},
+  }
+
+  for _, c := range cases {
+    result, err := genesysSystem.Roll(c.pool, EvalOptions{Roller: &scriptedRoller{faces: c.faces}})
+    if err != nil {
+      t.Fatalf("Rolling '%s' failed with error: %s", c.pool, err)
+    }
+    if !maps.Equal(result.Net, c.net) {
+      t.Fatalf("Rolling '%s' as %v left %v instead of %v", c.pool, c.faces, result.Net, c.net)
+    }
+    if result.Succeeded != c.succeeded || result.Checked != c.checked {
+      t.Fatalf("Rolling '%s' as %v: succeeded %v and checked %v, instead of %v and %v", c.pool, c.faces, result.Succeeded, result.Checked, c.succeeded, c.checked)
+    }
+  }
+}
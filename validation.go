@@ -2,6 +2,7 @@ package main
 
 import (
   "errors"
+  "fmt"
 )
 
 /* Checks if a macro name is valid.
@@ -22,13 +23,26 @@ func ValidateMacroName(name string) error {
  * no call to parse the macro when creating it.
  * No ValidateExpression function is necessary for non-macro
  * expressions, because those are immediately parsed. 
+ * The options should include the server's custom dice, so that
+ * macros can roll them.
  */
-func ValidateMacro(expression string) error {
+func ValidateMacro(expression string, options EvalOptions) error {
   inputs := make([]string, 26)
   for i := range inputs {
     inputs[i] = "1"
   }
 
-  _, _, err := ParseExpression(FillMacro(expression, inputs))
-  return err
+  rolls := SplitRolls(FillMacro(expression, inputs))
+  if len(rolls) == 0 {
+    return errors.New("Macro expression can't be empty.")
+  }
+  if len(rolls) > maxRollSections {
+    return errors.New(fmt.Sprintf("Macros can make at most %d rolls at once.", maxRollSections))
+  }
+  for _, roll := range rolls {
+    if _, err := ParseRepeatedExpression(roll.Expression, options); err != nil {
+      return err
+    }
+  }
+  return nil
 }